@@ -0,0 +1,38 @@
+/*
+Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+   http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Command aeconvert converts datastore entities between the formats supported
+by the aetools package, without connecting to any datastore:
+
+	json	JSON array, as written by aeremote --dump
+	ndjson	newline delimited JSON, one entity per line
+	yaml	YAML sequence of entities
+	csv	one entity per row, with the __key__ and property columns
+
+The formats are inferred from the file name extensions, or set with the
+--from and --to flags. When no files are given, the entities are read from
+the standard input and written to the standard output:
+
+	aeconvert --to yaml MyKind.json MyKind.yaml
+	aeconvert --from csv --to json --canonical < MyKind.csv > MyKind.json
+
+The --pretty and --canonical flags select the layout of the JSON output.
+
+In the CSV format, each cell holds the property value as encoded in the
+JSON format, and strings that are not valid JSON values are written without
+quotes, so the common case of plain strings and numbers is easy to edit
+in a spreadsheet.
+*/
+package main
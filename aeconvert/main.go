@@ -0,0 +1,97 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// Command line options
+var (
+	from      string // Input format.
+	to        string // Output format.
+	pretty    bool   // Pretty print the JSON output.
+	canonical bool   // Use the canonical JSON output.
+)
+
+func init() {
+	flag.StringVar(&from, "from", "", "Input format: json, ndjson, yaml or csv. Defaults to the input file extension, or json")
+	flag.StringVar(&to, "to", "", "Output format: json, ndjson, yaml or csv. Defaults to the output file extension, or json")
+	flag.BoolVar(&pretty, "pretty", false, "Pretty print the JSON output")
+	flag.BoolVar(&canonical, "canonical", false, "Use the canonical JSON output, suitable for SCM checkin")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: aeconvert [flags] [input [output]]\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := convert(flag.Arg(0), flag.Arg(1)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// convert reads the entities from the input file and writes them to the
+// output file. Empty file names are the standard input and output.
+func convert(input, output string) error {
+	inFormat, outFormat := format(from, input), format(to, output)
+
+	var r io.Reader = os.Stdin
+	if input != "" && input != "-" {
+		fd, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		r = fd
+	}
+	c := aetools.OfflineContext(context.Background())
+	entities, err := aetools.ReadEntities(c, r, inFormat)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", inFormat, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" && output != "-" {
+		fd, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		w = fd
+	}
+	o := &aetools.Options{PrettyPrint: pretty, Canonical: canonical}
+	if err := aetools.WriteEntities(w, entities, outFormat, o); err != nil {
+		return fmt.Errorf("Error writing %s: %v", outFormat, err)
+	}
+	log.Printf("Converted %d entities from %s to %s", len(entities), inFormat, outFormat)
+	return nil
+}
+
+// format returns the format set by the flag value, or the one inferred
+// from the file name, defaulting to JSON.
+func format(value, name string) string {
+	if value != "" {
+		return value
+	}
+	if f := aetools.FormatFromName(name); f != "" {
+		return f
+	}
+	return aetools.FormatJSON
+}
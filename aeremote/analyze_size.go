@@ -0,0 +1,73 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ronoaldo/aetools"
+)
+
+var analyzeSize = struct {
+	kind string
+	top  int
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("analyze-size", flag.ExitOnError)
+	fs.StringVar(&analyzeSize.kind, "kind", "", "Datastore kind to analyze")
+	fs.IntVar(&analyzeSize.top, "top", 10, "Number of largest entities and values to display")
+	register(&command{
+		Name:  "analyze-size",
+		Usage: "Report the largest entities and properties of a kind, and index size issues",
+		Flags: fs,
+		Run:   runAnalyzeSize,
+	})
+}
+
+func runAnalyzeSize(args []string) error {
+	if analyzeSize.kind == "" {
+		return errors.New("analyze-size: missing --kind")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	r, err := aetools.AnalyzeSize(c, analyzeSize.kind, analyzeSize.top, &aetools.Options{BatchSize: batchSize})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Kind %s: %d entities, %d bytes\n", r.Kind, r.Count, r.Bytes)
+
+	fmt.Fprintf(w, "\nLargest entities (limit is %d bytes):\n", aetools.MaxEntityBytes)
+	for _, e := range r.Largest {
+		fmt.Fprintf(w, "  %s\t%d bytes\t(%.1f%%)\n", aetools.KeyPath(e.Key), e.Bytes,
+			100*float64(e.Bytes)/aetools.MaxEntityBytes)
+		for _, p := range e.Properties {
+			fmt.Fprintf(w, "    %s\t%d bytes\t\n", p.Name, p.Bytes)
+		}
+	}
+
+	fmt.Fprintf(w, "\nProperties:\n  NAME\tVALUES\tINDEXED\tBYTES\tMAX BYTES\tINDEX BYTES\n")
+	for _, p := range r.Properties {
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\t%d\t%d\n", p.Name, p.Count, p.Indexed, p.Bytes, p.MaxBytes, p.IndexBytes)
+	}
+
+	fmt.Fprintf(w, "\nIndexed values close to the %d bytes limit:\n", aetools.MaxIndexedBytes)
+	for _, v := range r.NearLimit {
+		fmt.Fprintf(w, "  %s\t%s\t%d bytes\n", aetools.KeyPath(v.Key), v.Property, v.Bytes)
+	}
+
+	fmt.Fprintf(w, "\nIndexed properties with large values, that could be unindexed:\n")
+	for _, p := range r.NoIndexCandidates {
+		fmt.Fprintf(w, "  %s\t%d index bytes\t%d bytes per value\n", p.Name, p.IndexBytes, p.Bytes/int64(p.Count))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,359 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// manifestName is the name of the manifest file in a backup archive.
+const manifestName = "manifest.json"
+
+// manifest describes the contents of a backup archive.
+type manifest struct {
+	AppID         string         `json:"app_id"`
+	Timestamp     time.Time      `json:"timestamp"`
+	FormatVersion string         `json:"format_version"`
+	Kinds         []manifestKind `json:"kinds"`
+}
+
+// manifestKind describes the dump of a kind in a backup archive.
+type manifestKind struct {
+	Kind   string `json:"kind"`
+	File   string `json:"file"`
+	Count  int    `json:"count"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+var backup = struct {
+	output string
+	verify bool
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.StringVar(&backup.output, "output", "", "Archive file to write, defaults to the standard output")
+	register(&command{
+		Name:  "backup",
+		Usage: "Write a tar archive with the dump of the given kinds, or all kinds, and a manifest",
+		Flags: fs,
+		Run:   runBackup,
+	})
+
+	fs = flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.BoolVar(&backup.verify, "verify", false, "Only verify the archive checksums, without loading it")
+	register(&command{
+		Name:  "restore",
+		Usage: "Verify and load a tar archive created with backup",
+		Flags: fs,
+		Run:   runRestore,
+	})
+}
+
+func runBackup(kinds []string) error {
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	if len(kinds) == 0 {
+		if kinds, err = datastoreKinds(c); err != nil {
+			return err
+		}
+	}
+	m := &manifest{
+		AppID:         appengine.AppID(c),
+		Timestamp:     time.Now().UTC(),
+		FormatVersion: aetools.FormatVersion,
+	}
+
+	// Dump all kinds first, as the manifest is the first archive entry
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	for _, kind := range kinds {
+		log.Printf("Dumping entities of kind %s...", kind)
+		f, err := ioutil.TempFile("", "aeremote-backup")
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		h := sha256.New()
+		o, done := withProgress(kind, throttle(&aetools.Options{Kind: kind, BatchSize: batchSize}))
+		err = aetools.Dump(c, io.MultiWriter(f, h), o)
+		done()
+		if err != nil {
+			return fmt.Errorf("Error dumping kind %s: %v", kind, err)
+		}
+		size, err := f.Seek(0, os.SEEK_CUR)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		count, err := countEntities(f)
+		if err != nil {
+			return fmt.Errorf("Error verifying dump of kind %s: %v", kind, err)
+		}
+		m.Kinds = append(m.Kinds, manifestKind{
+			Kind:   kind,
+			File:   url.QueryEscape(kind) + ".json",
+			Count:  count,
+			Bytes:  size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+		log.Printf("Dumped %d entities of kind %s (%d bytes)", count, kind, size)
+	}
+
+	var w io.Writer = os.Stdout
+	if backup.output != "" {
+		out, err := os.Create(backup.output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+	tw := tar.NewWriter(w)
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, manifestName, int64(len(b)), m.Timestamp, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	for i, k := range m.Kinds {
+		if _, err := files[i].Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, k.File, k.Bytes, m.Timestamp, files[i]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return errors.New("restore: missing archive file name")
+	}
+	m, err := verifyArchive(args[0])
+	if err != nil {
+		return err
+	}
+	log.Printf("Archive %s verified: app %s, %d kinds, created at %s",
+		args[0], m.AppID, len(m.Kinds), m.Timestamp.Format(time.RFC3339))
+	if backup.verify {
+		return nil
+	}
+
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	fd, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	tr := tar.NewReader(fd)
+	failed := 0
+	for _, k := range m.Kinds {
+		hdr, err := nextFile(tr)
+		if err != nil {
+			return err
+		}
+		if hdr.Name != k.File {
+			return fmt.Errorf("restore: unexpected file %s, expected %s", hdr.Name, k.File)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loading %d entities of kind %s...", k.Count, k.Kind)
+		o, done := withProgress(k.Kind, throttle(&aetools.Options{BatchSize: batchSize}))
+		_, err = aetools.Load(c, bytes.NewReader(b), o)
+		done()
+		if err != nil {
+			return fmt.Errorf("Error loading kind %s: %v", k.Kind, err)
+		}
+		found, err := countExisting(c, b)
+		if err != nil {
+			return fmt.Errorf("Error verifying kind %s: %v", k.Kind, err)
+		}
+		if found != k.Count {
+			log.Printf("Restore of kind %s is incomplete: %d entities found, expected %d", k.Kind, found, k.Count)
+			failed++
+			continue
+		}
+		log.Printf("Restored %d entities of kind %s", found, k.Kind)
+	}
+	if failed > 0 {
+		return fmt.Errorf("restore: %d of %d kinds were not completely restored", failed, len(m.Kinds))
+	}
+	return nil
+}
+
+// verifyArchive reads the archive at path, checking the size and the
+// checksum of each kind file against the manifest.
+func verifyArchive(path string) (*manifest, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	tr := tar.NewReader(fd)
+
+	hdr, err := nextFile(tr)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("restore: missing %s, found %s", manifestName, hdr.Name)
+	}
+	m := new(manifest)
+	if err := json.NewDecoder(tr).Decode(m); err != nil {
+		return nil, fmt.Errorf("restore: invalid manifest: %v", err)
+	}
+	if m.FormatVersion != aetools.FormatVersion {
+		return nil, fmt.Errorf("restore: unsupported format version %q, expected %q",
+			m.FormatVersion, aetools.FormatVersion)
+	}
+
+	for _, k := range m.Kinds {
+		hdr, err := nextFile(tr)
+		if err == io.EOF {
+			return nil, fmt.Errorf("restore: missing file %s for kind %s", k.File, k.Kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name != k.File {
+			return nil, fmt.Errorf("restore: unexpected file %s, expected %s", hdr.Name, k.File)
+		}
+		h := sha256.New()
+		size, err := io.Copy(h, tr)
+		if err != nil {
+			return nil, err
+		}
+		if size != k.Bytes {
+			return nil, fmt.Errorf("restore: size mismatch for %s: %d bytes, expected %d", k.File, size, k.Bytes)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != k.SHA256 {
+			return nil, fmt.Errorf("restore: checksum mismatch for %s: %s, expected %s", k.File, sum, k.SHA256)
+		}
+	}
+	return m, nil
+}
+
+// writeTarFile writes a regular file entry to tw, copying size bytes from r.
+func writeTarFile(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// nextFile advances tr to the next regular file.
+func nextFile(tr *tar.Reader) (*tar.Header, error) {
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			return hdr, nil
+		}
+	}
+}
+
+// countEntities counts the elements of the JSON array read from r,
+// without decoding the entities.
+func countEntities(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return 0, err
+	} else if t != json.Delim('[') {
+		return 0, aetools.ErrInvalidRootElement
+	}
+	count := 0
+	for dec.More() {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// countExisting decodes the entities from the dump b and counts how many
+// of them exist in the datastore, using lookups by key.
+func countExisting(c context.Context, b []byte) (int, error) {
+	entities, err := aetools.DecodeEntities(c, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	size := batchSize
+	if size <= 0 {
+		size = 50
+	}
+	s := aetools.StoreFromContext(c)
+	found := 0
+	for start := 0; start < len(entities); start += size {
+		end := start + size
+		if end > len(entities) {
+			end = len(entities)
+		}
+		keys := make([]*datastore.Key, 0, end-start)
+		for _, e := range entities[start:end] {
+			keys = append(keys, e.Key)
+		}
+		err := s.GetMulti(c, keys, make([]aetools.Entity, len(keys)))
+		me, ok := err.(appengine.MultiError)
+		if err != nil && !ok {
+			return found, err
+		}
+		for i := range keys {
+			if me == nil || me[i] == nil {
+				found++
+			} else if me[i] != datastore.ErrNoSuchEntity {
+				return found, me[i]
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+var checkRefs = struct {
+	fixture StringList
+	samples int
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("check-refs", flag.ExitOnError)
+	fs.Var(&checkRefs.fixture, "fixture", "Fixture files to check, instead of the datastore")
+	fs.IntVar(&checkRefs.samples, "samples", 10, "Maximum number of dangling references displayed per property")
+	register(&command{
+		Name:  "check-refs",
+		Usage: "Report key properties of the given kinds pointing to missing entities",
+		Flags: fs,
+		Run:   runCheckRefs,
+	})
+}
+
+func runCheckRefs(kinds []string) error {
+	var (
+		c   context.Context
+		err error
+	)
+	if len(checkRefs.fixture) > 0 {
+		c = aetools.NewMemoryContext(context.Background())
+		found := make(map[string]bool)
+		for _, f := range checkRefs.fixture {
+			fd, err := os.Open(f)
+			if err != nil {
+				return err
+			}
+			entities, err := aetools.DecodeEntities(c, fd)
+			fd.Close()
+			if err != nil {
+				return fmt.Errorf("Error decoding fixture %s: %s", f, err.Error())
+			}
+			keys := make([]*datastore.Key, len(entities))
+			for i, e := range entities {
+				keys[i] = e.Key
+				found[e.Key.Kind()] = true
+			}
+			if _, err := aetools.StoreFromContext(c).PutMulti(c, keys, entities); err != nil {
+				return err
+			}
+		}
+		if len(kinds) == 0 {
+			for k := range found {
+				kinds = append(kinds, k)
+			}
+			sort.Strings(kinds)
+		}
+	} else {
+		if c, err = remoteContext(); err != nil {
+			return err
+		}
+		if len(kinds) == 0 {
+			if kinds, err = statKinds(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	reports, err := aetools.CheckReferences(c, kinds, checkRefs.samples, &aetools.Options{BatchSize: batchSize})
+	if err != nil {
+		return err
+	}
+	dangling := 0
+	for _, r := range reports {
+		fmt.Printf("%s: %d of %d references are dangling\n", r.Property, r.Dangling, r.Count)
+		for _, s := range r.Samples {
+			fmt.Printf("\t%s -> %s\n", aetools.KeyPath(s.From), aetools.KeyPath(s.To))
+		}
+		dangling += r.Dangling
+	}
+	if dangling > 0 {
+		return fmt.Errorf("check-refs: %d dangling references found", dangling)
+	}
+	return nil
+}
@@ -0,0 +1,672 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// Flags of the datastore commands.
+var (
+	format   string // Format of the entities read or written.
+	input    string // File with the entities to delete.
+	dryRun   bool   // Only report the entities to delete.
+	limit    int    // Maximum number of query results.
+	keysOnly bool   // Query only the keys.
+	ancestor string // Ancestor key of the query.
+)
+
+// formatFlag defines the --format flag, with the given help.
+func formatFlag(fs *flag.FlagSet, help string) {
+	fs.StringVar(&format, "format", "", help)
+}
+
+func init() {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.StringVar(&dumpDir, "dir", "", "Directory to export the kind, with one file per entity, instead of the standard output")
+	outputFlags(fs)
+	dumpFlags(fs)
+	batchFlags(fs)
+	throttleFlags(fs)
+	progressFlag(fs)
+	register(&command{
+		Name:  "dump",
+		Args:  "KIND",
+		Usage: "Export all entities of a kind as a JSON array, or into a directory",
+		Help: `
+The entities are written to the standard output, or to one file per entity
+in the --dir directory. An interrupted dump to the standard output logs the
+command to resume it with --start; a dump into a directory is resumed by
+running the same command again.`,
+		Flags: fs,
+		Run:   runDump,
+	})
+
+	fs = flag.NewFlagSet("load", flag.ExitOnError)
+	formatFlag(fs, "Format of the fixtures: json, ndjson, yaml or csv. Defaults to the file extension, or json for the standard input")
+	loadFlags(fs)
+	batchFlags(fs)
+	throttleFlags(fs)
+	progressFlag(fs)
+	register(&command{
+		Name:  "load",
+		Args:  "FILE|DIR|-...",
+		Usage: "Import the entities of fixture files and directories",
+		Help: `
+Each argument is a fixture file, a directory with .json fixtures, like the
+ones written by dump --dir, or - for the standard input. The exit code is 3
+when some, but not all, entities were loaded.`,
+		Flags: fs,
+		Run:   runLoad,
+	})
+
+	fs = flag.NewFlagSet("get", flag.ExitOnError)
+	formatFlag(fs, "Output format: json, ndjson, yaml, csv or table")
+	outputFlags(fs)
+	register(&command{
+		Name:  "get",
+		Args:  "KEY...",
+		Usage: "Export the entities with the given keys",
+		Help: `
+Keys are encoded keys, key paths like User,123 or JSON keys like ["User",123].
+Missing keys are logged, and the exit code is 3 if any key is missing.`,
+		Flags: fs,
+		Run:   runGet,
+	})
+
+	fs = flag.NewFlagSet("delete", flag.ExitOnError)
+	fs.StringVar(&input, "input", "", "File with the entities to delete, or - for the standard input, instead of the keys in the arguments")
+	formatFlag(fs, "Format of the --input entities: json, ndjson, yaml or csv. Defaults to the file extension, or json for the standard input")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only list the keys that would be deleted")
+	batchFlags(fs)
+	register(&command{
+		Name:  "delete",
+		Args:  "KEY... | --input FILE|-",
+		Usage: "Delete the entities with the given keys, or read from a file",
+		Help: `
+Keys are given like in the get command, or read from the entities in the
+--input file, so the output of dump, get and query can be piped into delete:
+
+	aeremote query --keys-only --format ndjson Session | aeremote delete --format ndjson --input -`,
+		Flags: fs,
+		Run:   runDelete,
+	})
+
+	fs = flag.NewFlagSet("query", flag.ExitOnError)
+	formatFlag(fs, "Output format: json, ndjson, yaml, csv or table")
+	outputFlags(fs)
+	fs.IntVar(&limit, "limit", 0, "Maximum number of entities, 0 for no limit")
+	fs.BoolVar(&keysOnly, "keys-only", false, "Export only the entity keys")
+	fs.StringVar(&ancestor, "ancestor", "", "Export only the descendants of this key")
+	register(&command{
+		Name:  "query",
+		Args:  "GQL | KIND",
+		Usage: "Export the entities matching a GQL query, or all entities of a kind",
+		Help: `
+The query is written in a subset of GQL, usually quoted as one argument:
+
+	SELECT * | __key__ | property, ... FROM Kind
+	[WHERE condition [AND condition]...]
+	[ORDER BY property [ASC | DESC], ...]
+	[LIMIT n] [OFFSET m]
+
+Conditions use =, <, <=, >, >=, IS NULL and __key__ HAS ANCESTOR KEY(...).
+Values are quoted strings, numbers, TRUE, FALSE, NULL, dates like
+DATETIME('2006-01-02T15:04:05Z') and keys like KEY(Kind, id, ...) or
+KEY('Kind,id'). The --limit, --keys-only and --ancestor flags override the
+query clauses. Only the selected properties are written. The JSON and
+NDJSON output of SELECT * can be piped into load and delete, and the table
+format is aligned for reading:
+
+	aeremote query --format table "SELECT * FROM Order WHERE status = 'open' ORDER BY created LIMIT 100"`,
+		Flags: fs,
+		Run:   runQuery,
+	})
+
+	register(&command{
+		Name:  "stats",
+		Args:  "[KIND...]",
+		Usage: "Show the datastore statistics of all kinds, or of the given kinds",
+		Help: `
+This is the default command, when no command or flag is given.`,
+		Flags: flag.NewFlagSet("stats", flag.ExitOnError),
+		Run:   runStats,
+	})
+
+	register(&command{
+		Name:  "kinds",
+		Usage: "List the datastore kinds, one per line",
+		Flags: flag.NewFlagSet("kinds", flag.ExitOnError),
+		Run:   runKinds,
+	})
+
+	fs = flag.NewFlagSet("dump-index", flag.ExitOnError)
+	outputFlags(fs)
+	batchFlags(fs)
+	register(&command{
+		Name:  "dump-index",
+		Args:  "INDEX",
+		Usage: "Export the documents of a Search API index as a JSON array",
+		Flags: fs,
+		Run:   runDumpIndex,
+	})
+
+	fs = flag.NewFlagSet("load-index", flag.ExitOnError)
+	batchFlags(fs)
+	register(&command{
+		Name:  "load-index",
+		Args:  "INDEX=FILE|FILE...",
+		Usage: "Import Search API documents, using the file name without extension as the default index name",
+		Flags: fs,
+		Run:   runLoadIndex,
+	})
+}
+
+func runDump(args []string) error {
+	if len(args) != 1 {
+		return usageError("dump: needs one kind")
+	}
+	kind := args[0]
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	c, stop := interruptible(c)
+	defer stop()
+	o, done := withProgress(kind, dumpOptions(kind))
+	if dumpDir != "" {
+		log.Printf("Dumping entities of kind %s into %s...\n", kind, dumpDir)
+		err = aetools.DumpDir(c, dumpDir, o)
+		done()
+		if _, ok := interrupted(err); ok {
+			log.Printf("Dump of %s interrupted, run the same command again to resume", kind)
+		}
+		return err
+	}
+	log.Printf("Dumping entities of kind %s...\n", kind)
+	err = aetools.Dump(c, os.Stdout, o)
+	done()
+	if ie, ok := interrupted(err); ok {
+		log.Printf("Dump of %s interrupted after %d entities", kind, ie.Next)
+		resumeHint("dump", "--start", ie.Cursor, kind)
+	}
+	return err
+}
+
+func runLoad(args []string) error {
+	if len(args) == 0 {
+		return usageError("load: needs at least one file or directory")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	c, stop := interruptible(c)
+	defer stop()
+	log.Println("Loading entities ...")
+	failed, written := 0, 0
+	for i, f := range args {
+		r, err := loadFixture(c, f)
+		if ie, ok := interrupted(err); ok {
+			reportLoad(f, r, nil)
+			resumeHint("load", "--skip", fmt.Sprint(ie.Next), f)
+			for _, f := range args[i+1:] {
+				log.Printf("Fixture %s was not loaded", f)
+			}
+			return err
+		}
+		if !reportLoad(f, r, err) {
+			failed++
+		}
+		written += r.Written
+	}
+	if failed == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("load: %d of %d fixtures were not completely loaded", failed, len(args))
+	if written > 0 {
+		return incompleteError(msg)
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// loadFixture loads the entities of the fixture file or directory f.
+func loadFixture(c context.Context, f string) (*aetools.LoadResult, error) {
+	o, done := withProgress(f, loadOptions())
+	defer done()
+	if fi, err := os.Stat(f); err == nil && fi.IsDir() {
+		return aetools.LoadDir(c, f, o)
+	}
+	r, err := readFixture(c, f)
+	if err != nil {
+		return new(aetools.LoadResult), err
+	}
+	return aetools.Load(c, r, o)
+}
+
+// readFixture returns the JSON array with the entities in file, or in the
+// standard input if file is "-", converting them from the --format or the
+// file extension format.
+func readFixture(c context.Context, file string) (io.Reader, error) {
+	b, err := readInput(file)
+	if err != nil {
+		return nil, err
+	}
+	f := inputFormat(file)
+	if f == aetools.FormatJSON {
+		return bytes.NewReader(b), nil
+	}
+	entities, err := aetools.ReadEntities(c, bytes.NewReader(b), f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := aetools.WriteEntities(&buf, entities, aetools.FormatJSON, &aetools.Options{}); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// readInput reads file, or the standard input if file is "-".
+func readInput(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// inputFormat returns the format of the entities in file, given with
+// --format or by the file extension. JSON is the default.
+func inputFormat(file string) string {
+	if format != "" {
+		return format
+	}
+	if f := aetools.FormatFromName(file); f != "" {
+		return f
+	}
+	return aetools.FormatJSON
+}
+
+// writeEntities writes entities to the standard output using
+// the --format, --pretty and --canonical flags. The table format
+// has the given columns, or all properties if columns is empty.
+func writeEntities(entities []aetools.Entity, columns []string) error {
+	f := format
+	if f == "" {
+		f = aetools.FormatJSON
+	}
+	if f == formatTable {
+		return writeTable(os.Stdout, entities, columns)
+	}
+	err := aetools.WriteEntities(os.Stdout, entities, f, &aetools.Options{PrettyPrint: pretty, Canonical: canonical})
+	if err == nil && f == aetools.FormatJSON && !canonical {
+		_, err = fmt.Println()
+	}
+	return err
+}
+
+// parseKeys parses the keys in args.
+func parseKeys(c context.Context, args []string) ([]*datastore.Key, error) {
+	keys := make([]*datastore.Key, 0, len(args))
+	for _, s := range args {
+		k, err := aetools.ParseKey(c, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", s, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func runGet(args []string) error {
+	if len(args) == 0 {
+		return usageError("get: needs at least one key")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeys(c, args)
+	if err != nil {
+		return usageError("get: " + err.Error())
+	}
+	l := make([]aetools.Entity, len(keys))
+	err = aetools.StoreFromContext(c).GetMulti(c, keys, l)
+	me, ok := err.(appengine.MultiError)
+	if err != nil && !ok {
+		return err
+	}
+	found := make([]aetools.Entity, 0, len(keys))
+	missing := 0
+	for i := range keys {
+		if ok && me[i] != nil {
+			if me[i] != datastore.ErrNoSuchEntity {
+				return fmt.Errorf("get: error loading %s: %v", args[i], me[i])
+			}
+			log.Printf("Key %s not found", args[i])
+			missing++
+			continue
+		}
+		l[i].Key = keys[i]
+		found = append(found, l[i])
+	}
+	if err := writeEntities(found, nil); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return incompleteError(fmt.Sprintf("get: %d of %d keys not found", missing, len(keys)))
+	}
+	return nil
+}
+
+func runDelete(args []string) error {
+	if (len(args) == 0) == (input == "") {
+		return usageError("delete: needs either keys or the --input file")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	var keys []*datastore.Key
+	if input != "" {
+		b, err := readInput(input)
+		if err != nil {
+			return err
+		}
+		entities, err := aetools.ReadEntities(c, bytes.NewReader(b), inputFormat(input))
+		if err != nil {
+			return err
+		}
+		for _, e := range entities {
+			if e.Key == nil || e.Key.Incomplete() {
+				return fmt.Errorf("delete: entity without a complete key in %s", input)
+			}
+			keys = append(keys, e.Key)
+		}
+	} else if keys, err = parseKeys(c, args); err != nil {
+		return usageError("delete: " + err.Error())
+	}
+	if dryRun {
+		for _, k := range keys {
+			fmt.Println(k)
+		}
+		log.Printf("Would delete %d entities", len(keys))
+		return nil
+	}
+	size := batchSize
+	if size <= 0 {
+		size = len(keys)
+	}
+	deleted := 0
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := aetools.StoreFromContext(c).DeleteMulti(c, keys[start:end]); err != nil {
+			msg := fmt.Sprintf("delete: error deleting keys %d to %d: %v", start, end-1, err)
+			log.Printf("Deleted %d entities", deleted)
+			if deleted > 0 {
+				return incompleteError(msg)
+			}
+			return fmt.Errorf("%s", msg)
+		}
+		deleted = end
+	}
+	log.Printf("Deleted %d entities", deleted)
+	return nil
+}
+
+func runQuery(args []string) error {
+	if len(args) == 0 {
+		return usageError("query: needs a GQL query or a kind")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	var (
+		q          *aetools.Query
+		properties []string
+	)
+	if f := strings.Fields(args[0]); len(f) > 0 && strings.EqualFold(f[0], "SELECT") {
+		// Unquoted queries are split by the shell
+		if q, properties, err = aetools.ParseGQL(c, strings.Join(args, " ")); err != nil {
+			return usageError("query: " + err.Error())
+		}
+	} else if len(args) == 1 {
+		q = &aetools.Query{Kind: args[0]}
+	} else {
+		return usageError("query: needs one GQL query or kind")
+	}
+	if limit > 0 {
+		q.Limit = limit
+	}
+	if keysOnly {
+		q.KeysOnly = true
+	}
+	if ancestor != "" {
+		if q.Ancestor, err = aetools.ParseKey(c, ancestor); err != nil {
+			return usageError(fmt.Sprintf("query: invalid ancestor %s: %v", ancestor, err))
+		}
+	}
+	if f := format; f == "" || f == aetools.FormatJSON || f == aetools.FormatNDJSON {
+		return streamEntities(c, q, properties)
+	}
+	entities, err := runEntities(c, q)
+	if err != nil {
+		return err
+	}
+	if len(properties) > 0 {
+		project(entities, properties)
+	}
+	return writeEntities(entities, properties)
+}
+
+// streamEntities writes the entities returned by q to the standard output
+// as they are read, in the JSON or NDJSON --format and with the given
+// properties, or all properties if properties is empty. The output is the
+// same of writeEntities.
+func streamEntities(c context.Context, q *aetools.Query, properties []string) error {
+	open, separator, end := "[", ",\n", "]\n"
+	switch {
+	case format == aetools.FormatNDJSON:
+		open, separator, end = "", "\n", "\n"
+	case canonical:
+		open, end = "[\n", "\n]\n"
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	w.WriteString(open)
+	count := 0
+	entity := make([]aetools.Entity, 1)
+	for it := aetools.StoreFromContext(c).Run(c, q); ; count++ {
+		entity[0] = aetools.Entity{}
+		e := &entity[0]
+		k, err := it.Next(e)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		e.Key = k
+		if len(properties) > 0 {
+			project(entity, properties)
+		}
+		var b []byte
+		switch {
+		case format == aetools.FormatNDJSON:
+			b, err = json.Marshal(e)
+		case canonical:
+			b, err = e.MarshalCanonical()
+		case pretty:
+			b, err = json.MarshalIndent(e, "", "  ")
+		default:
+			b, err = json.Marshal(e)
+		}
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			w.WriteString(separator)
+		}
+		w.Write(b)
+	}
+	if count == 0 && format == aetools.FormatNDJSON {
+		return nil
+	}
+	if count == 0 && canonical {
+		end = "]\n"
+	}
+	w.WriteString(end)
+	return w.Flush()
+}
+
+// project removes the properties of entities not in names.
+func project(entities []aetools.Entity, names []string) {
+	keep := make(map[string]bool)
+	for _, n := range names {
+		keep[n] = true
+	}
+	for i := range entities {
+		props := entities[i].Properties[:0]
+		for _, p := range entities[i].Properties {
+			if keep[p.Name] {
+				props = append(props, p)
+			}
+		}
+		entities[i].Properties = props
+	}
+}
+
+// runEntities returns all entities returned by q.
+func runEntities(c context.Context, q *aetools.Query) ([]aetools.Entity, error) {
+	var entities []aetools.Entity
+	for it := aetools.StoreFromContext(c).Run(c, q); ; {
+		var e aetools.Entity
+		k, err := it.Next(&e)
+		if err == datastore.Done {
+			return entities, nil
+		}
+		if err != nil {
+			return entities, err
+		}
+		e.Key = k
+		entities = append(entities, e)
+	}
+}
+
+func runStats(kinds []string) error {
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	if len(kinds) == 0 {
+		return aetools.Dump(c, os.Stdout, &aetools.Options{Kind: StatKind, PrettyPrint: true, BatchSize: batchSize})
+	}
+	var stats []aetools.Entity
+	for _, k := range kinds {
+		q := &aetools.Query{Kind: StatKind, Filters: []aetools.Filter{{Property: "kind_name", Operator: "=", Value: k}}}
+		l, err := runEntities(c, q)
+		if err != nil {
+			return err
+		}
+		if len(l) == 0 {
+			log.Printf("No statistics for kind %s", k)
+		}
+		stats = append(stats, l...)
+	}
+	if err := aetools.WriteEntities(os.Stdout, stats, aetools.FormatJSON, &aetools.Options{PrettyPrint: true}); err != nil {
+		return err
+	}
+	_, err = fmt.Println()
+	return err
+}
+
+func runKinds(args []string) error {
+	if len(args) > 0 {
+		return usageError("kinds: takes no arguments")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	kinds, err := datastoreKinds(c)
+	if err != nil {
+		return err
+	}
+	for _, k := range kinds {
+		fmt.Println(k)
+	}
+	return nil
+}
+
+// datastoreKinds returns the kinds in the datastore, using the __kind__
+// metadata query, that unlike the statistics is always up to date. The
+// kinds starting with "__" are not returned.
+func datastoreKinds(c context.Context) ([]string, error) {
+	keys, err := runEntities(c, &aetools.Query{Kind: "__kind__", KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	var kinds []string
+	for _, e := range keys {
+		if k := e.Key.StringID(); !strings.HasPrefix(k, "__") {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds, nil
+}
+
+func runDumpIndex(args []string) error {
+	if len(args) != 1 {
+		return usageError("dump-index: needs one index name")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	log.Printf("Dumping documents of index %s...\n", args[0])
+	return aetools.DumpIndex(c, os.Stdout, args[0], dumpOptions(""))
+}
+
+func runLoadIndex(args []string) error {
+	if len(args) == 0 {
+		return usageError("load-index: needs at least one file")
+	}
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	for _, f := range args {
+		name, file := indexFixture(f)
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		n, err := aetools.LoadIndex(c, fd, name, loadOptions())
+		fd.Close()
+		log.Printf("Loaded %d documents from %s into index %s", n, file, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -16,22 +16,261 @@ limitations under the License.
 Command aeremote is a simple Remote API client to download and upload
 data on Google App Engine Datastore.
 
+Commands
+
+Each operation is a command, given after the global flags, like --host and
+--port, and followed by its own flags and arguments:
+
+	aeremote [global flags] command [command flags] [args]
+
+Run "aeremote help" for the list of commands, and "aeremote help COMMAND"
+or "aeremote COMMAND -h" for the help of a command. Without a command,
+aeremote shows the datastore statistics, like the stats command.
+
+The exit code is 0 on success, 1 when the command fails, 2 for an invalid
+command line, 3 when the command finished but some entities or keys were
+not processed, like missing keys or entities not loaded, and 130 when it
+was interrupted.
+
 Dumping entities from development server
 
 One use case is to export your local datastore as a JSON file to be
 reused as a fixture in automated tests, or to bootstrap your app for
-local development. This can be done by using the --dump option,
+local development. This can be done by using the dump command,
 followed by the datastore kind to export:
 
-	aeremote --dump MyKind > MyKind.json
+	aeremote dump MyKind > MyKind.json
+
+Use the --canonical option to export the entities in a stable layout,
+with one property per line, to keep fixture diffs small:
+
+	aeremote dump --canonical MyKind > MyKind.json
+
+Single entities can be exported with the get command, using the encoded
+key, a key path or a JSON key:
+
+	aeremote get 'Parent,1,MyKind,name' > entity.json
+	aeremote get '["Parent",1,"MyKind","name"]' > entity.json
 
 Loading fixtures in the development server
 
 To load a previously exported fixture back into the datastore, to restore
-a previous exported state or to bootstrap your app, you can use the --load
-option:
+a previous exported state or to bootstrap your app, you can use the load
+command:
+
+	aeremote load MyKind.json MyOtherKind.json
+
+Fixtures can also be in the NDJSON, YAML or CSV formats, using the file
+extension or the --format option, and "-" reads the standard input.
+
+A report with the number of entities read, written, skipped and failed is
+logged for each fixture. By default, loading a fixture stops at the first
+error; use --continue-on-error to load all valid entities and report the
+failed ones with their position and key. The exit status is non-zero if
+any entity was not loaded.
+
+Large fixtures can be loaded faster with --workers, the number of batches
+saved concurrently. When many entities share the same entity group, add
+--serialize-groups so that two batches with entities of the same group
+are never saved at the same time, avoiding contention errors:
+
+	aeremote load --workers 8 --serialize-groups MyKind.json
+
+While dumping or loading, a progress line is shown on stderr with the
+number of entities and bytes processed, the elapsed time and, when the
+total is known, an estimate of the remaining time. When dumping, the total
+comes from the datastore statistics, so it may be outdated. Use
+--progress=false to hide it.
+
+Dumps and loads can be interrupted with Ctrl-C (SIGINT) or SIGTERM: the
+current batch is finished, the output is closed as a valid JSON array,
+and the command to resume is printed. Dumps are resumed with the --start
+cursor, and loads with --skip, the number of entities to skip in the
+fixture. Interrupt again to exit immediately:
+
+	aeremote dump --start <cursor> MyKind > MyKind-2.json
+	aeremote load --skip 1500 MyKind.json
+
+To keep fixtures under version control, use the --dir option to write
+each entity to its own file, at <dir>/<Kind>/<key path>.json, so changes in
+different entities don't conflict. Directories given to load are walked,
+loading all .json files back:
+
+	aeremote dump --canonical --dir fixtures MyKind
+	aeremote load fixtures
+
+The flags of previous versions are still supported, and run the equivalent
+command: --dump (with --dump-dir), --dump-entity, --load, --load-dir,
+--dump-index and --load-index.
+
+Querying and deleting entities
+
+The query command exports the entities matching a query written in a
+subset of GQL, with filters, ancestors, sort orders, limit and offset, or
+all entities of a kind. Use --format to write JSON, NDJSON, YAML, CSV or an
+aligned table, and "aeremote help query" for the supported syntax. The
+kinds command lists the datastore kinds:
+
+	aeremote kinds
+	aeremote query --format table "SELECT * FROM Order WHERE status = 'open' AND created > DATETIME('2017-03-01T00:00:00Z') ORDER BY created LIMIT 100"
+	aeremote query --limit 10 --format ndjson MyKind
+
+The JSON and NDJSON output of queries selecting all properties can be
+loaded back with load, for instance into another server.
+
+The delete command removes the entities with the given keys, or with the
+keys of the entities in the --input file, so the output of query, get and
+dump can be piped into it. Use --dry-run to only list the keys:
+
+	aeremote delete 'MyKind,123'
+	aeremote query --keys-only MyKind | aeremote delete --input -
+
+Interactive shell
+
+The shell command reads commands from the standard input, one per line,
+reusing the same connection. Errors are reported and the shell continues:
+
+	$ aeremote --host localhost --port 8888 shell
+	localhost:8888> kinds
+	localhost:8888> get 'MyKind,123'
+	localhost:8888> exit
+
+Search API indexes
+
+The documents of a Search API index are exported with dump-index, and
+loaded back with load-index, either as INDEX=FILE or just a file name,
+using the name without the extension as the index name:
+
+	aeremote dump-index products > products.json
+	aeremote load-index products.json
+	aeremote load-index products-qa=products.json
+
+Backup and restore
+
+The backup command writes a single tar archive with the dump of each kind,
+and a manifest.json file with the application ID, the creation time, the
+aetools format version, and the entity count, size and SHA-256 checksum of
+each kind. If no kind is given, all kinds in the datastore are exported:
+
+	aeremote backup --output backup.tar MyKind MyOtherKind
+
+The restore command checks the archive against the manifest before loading
+anything, and after loading each kind, verifies that all entities from the
+archive can be found in the datastore. Use --verify to only check the archive:
 
-	aeremote --load MyKind.json --load MyOtherKind.json
+	aeremote restore backup.tar
+
+Checking references
+
+The check-refs command scans the given kinds, or all kinds with statistics,
+for key properties pointing to entities that don't exist, and reports them
+grouped by kind and property, with some samples. It exits with an error if
+any dangling reference is found. Use --fixture to check fixture files
+offline, against the fixture entities only:
+
+	aeremote check-refs Order Invoice
+	aeremote check-refs --fixture Customer.json --fixture Order.json
+
+Analyzing entity sizes
+
+The analyze-size command scans a kind and reports the estimated size of the
+largest entities, with the size of each property, the size statistics of all
+properties, the indexed values close to the 1500 bytes limit, and the indexed
+properties with large values that could be unindexed:
+
+	aeremote analyze-size --kind MyKind --top 20
+
+Finding property type drift
+
+The type-drift command lists the properties saved with more than one type,
+like integers in old entities and strings in new ones, with the number of
+values of each type. By default the datastore statistics are used; with
+--scan, all entities are read, and up to --samples example keys are shown for
+each type:
+
+	aeremote type-drift --scan MyKind
+
+Running data migrations
+
+The migrate command runs the data migrations registered with the
+github.com/ronoaldo/aetools/migrate package, recording the applied ones in
+the datastore. Migrations are Go code, so they must be compiled into the
+binary. The stock aeremote binary has no migrations: it only lists the ones
+recorded in the datastore, and both up and status fail reporting that no
+migrations are registered. To run your migrations, copy the aeremote source
+and add a file that registers them in an init function:
+
+	package main
+
+	import "github.com/ronoaldo/aetools/migrate"
+
+	func init() {
+		migrate.Register(&migrate.Migration{ID: 1, Name: "Split user name", Kind: "User", Func: splitName})
+	}
+
+Then build it and run the migrate command as usual:
+
+	aeremote migrate status
+	aeremote migrate up --dry-run
+	aeremote migrate up --to 3
+
+A standalone command that calls migrate.Main can also be used; see the
+example in the migrate package documentation.
+
+Inspecting memcache
+
+The memcache command reads and changes the memcache values of the app over
+the Remote API. Values are printed as JSON: values that are compact JSON are
+used as is, unless they are objects with a "type" attribute, and other values
+are printed as {"type": "string", "value": ...}, or as {"type": "blob",
+"value": ...} with URL safe base64 for binary data:
+
+	aeremote memcache get user:123 user:456 > items.json
+	aeremote memcache delete user:123
+	aeremote memcache flush
+	aeremote memcache stats
+
+The set subcommand saves the items of a file in the same format printed by
+get, with an optional expiration in seconds, or from the standard input
+with "-". Use --namespace for keys in a namespace:
+
+	[{"key": "config", "value": {"maintenance": true}, "expiration": 3600}]
+
+	aeremote memcache --namespace qa set items.json
+
+Enqueuing tasks
+
+The tasks command adds tasks to a queue from a JSON file, or from the
+standard input with "-". Each task has the request path, and optionally
+the method (POST by default), the payload or form params, the headers,
+the ETA in the RFC3339 format and the task name:
+
+	[
+	  {"path": "/bq/sync/range?startKey=...", "method": "GET"},
+	  {"path": "/tasks/backfill", "params": {"kind": "Order"}, "eta": "2017-03-01T03:00:00Z"}
+	]
+
+	aeremote tasks add --queue backfill tasks.json
+
+All tasks of a queue can be removed with purge, that always needs the
+--queue flag, and stats prints the number of tasks in the queue and its
+execution rate:
+
+	aeremote tasks purge --queue backfill
+	aeremote tasks stats --queue backfill
+
+Generating Go structs
+
+The gen-structs command prints Go struct definitions for the given kinds,
+inferred from the datastore statistics. If no kind is given, all kinds
+with statistics are generated:
+
+	aeremote gen-structs --package models MyKind MyOtherKind > models.go
+
+The types can also be inferred from previously exported fixture files,
+without connecting to the server:
+
+	aeremote gen-structs --fixture MyKind.json MyKind > models.go
 
 Interacting with deployed apps
 
@@ -49,18 +288,26 @@ the Google Cloud SDK [2]. Onc you install the SDK on your computer,
 run `gcloud auth login` command to authenticate, then just make a
 aeremote call using the --host and --port parameters:
 
-	aeremote -host your-app-id.appspot.com -port 443 --dump MyKind > MyKind.json
+	aeremote -host your-app-id.appspot.com -port 443 dump MyKind > MyKind.json
 
 NOTE: for this to work, your remote application must have an updated version
 of the Remote API handler, in any of the supported runtimes. If you have
 deployed your app a long time ago, you may need to redeploy if aeremote
 outputs a login page as an error message.
 
-CAUTION: if you --load data using aeremote into your appspot.com application,
+CAUTION: if you load data using aeremote into your appspot.com application,
 be aware that this is a raw datastore operation, and any datastore logic that
 you have is not executed, i.e., if you have entitites annotated with
 "@PrePersist" in Java, aeremote does not execute any of that logic.
 
+To reduce the impact on the user traffic of a live application, use the
+--max-qps and --max-entities-per-second options to limit the rate of
+datastore calls when dumping or loading. Batches that fail with datastore
+timeouts or contention errors are retried with exponential backoff, up to
+--retries times:
+
+	aeremote -host your-app-id.appspot.com -port 443 load --max-entities-per-second 200 MyKind.json
+
 
 References
 
@@ -0,0 +1,107 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+	"github.com/ronoaldo/aetools/bigquerysync"
+	"github.com/ronoaldo/aetools/structgen"
+)
+
+var genStructs = struct {
+	pkg     string
+	fixture StringList
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("gen-structs", flag.ExitOnError)
+	fs.StringVar(&genStructs.pkg, "package", "models", "Package name of the generated source")
+	fs.Var(&genStructs.fixture, "fixture", "Fixture files to infer types from, instead of datastore statistics")
+	register(&command{
+		Name:  "gen-structs",
+		Usage: "Generate Go structs for the given kinds from datastore statistics or fixtures",
+		Flags: fs,
+		Run:   runGenStructs,
+	})
+}
+
+func runGenStructs(kinds []string) error {
+	var structs []*structgen.Struct
+	if len(genStructs.fixture) > 0 {
+		c := aetools.OfflineContext(context.Background())
+		byKind := make(map[string][]aetools.Entity)
+		for _, f := range genStructs.fixture {
+			fd, err := os.Open(f)
+			if err != nil {
+				return err
+			}
+			entities, err := aetools.DecodeEntities(c, fd)
+			fd.Close()
+			if err != nil {
+				return fmt.Errorf("Error decoding fixture %s: %s", f, err.Error())
+			}
+			for _, e := range entities {
+				k := e.Key.Kind()
+				byKind[k] = append(byKind[k], e)
+			}
+		}
+		if len(kinds) == 0 {
+			for k := range byKind {
+				kinds = append(kinds, k)
+			}
+			sort.Strings(kinds)
+		}
+		for _, k := range kinds {
+			structs = append(structs, structgen.FromEntities(k, byKind[k]))
+		}
+	} else {
+		c, err := remoteContext()
+		if err != nil {
+			return err
+		}
+		if len(kinds) == 0 {
+			if kinds, err = statKinds(c); err != nil {
+				return err
+			}
+		}
+		for _, k := range kinds {
+			s, err := structgen.FromStats(c, k)
+			if err != nil {
+				return err
+			}
+			structs = append(structs, s)
+		}
+	}
+	return structgen.Write(os.Stdout, genStructs.pkg, structs...)
+}
+
+// statKinds returns the user kind names found in the datastore statistics.
+func statKinds(c context.Context) ([]string, error) {
+	var kinds []string
+	q := &aetools.Query{Kind: bigquerysync.StatByKindKind}
+	for it := aetools.StoreFromContext(c).Run(c, q); ; {
+		var e aetools.Entity
+		_, err := it.Next(&e)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if k := e.GetString("kind_name"); k != "" && !strings.HasPrefix(k, "__") {
+			kinds = append(kinds, k)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
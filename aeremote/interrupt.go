@@ -0,0 +1,66 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// interruptible returns a context that is canceled by the first SIGINT or
+// SIGTERM, so dumps and loads finish the current batch and stop. A second
+// signal exits immediately. The stop function must be called when the
+// operation is done, to restore the default signal handling.
+func interruptible(parent context.Context) (context.Context, func()) {
+	c, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 2)
+	done := make(chan bool)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+		case <-done:
+			return
+		}
+		log.Printf("Interrupted, finishing the current batch (interrupt again to exit now) ...")
+		cancel()
+		select {
+		case <-ch:
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	return c, func() {
+		signal.Stop(ch)
+		close(done)
+		cancel()
+	}
+}
+
+// interrupted reports if err is an aetools.InterruptedError.
+func interrupted(err error) (*aetools.InterruptedError, bool) {
+	ie, ok := err.(*aetools.InterruptedError)
+	return ie, ok
+}
+
+// resumeHint logs the aeremote command to resume an interrupted operation,
+// with the connection flags followed by args.
+func resumeHint(args ...string) {
+	cmd := []string{"aeremote", "--host", host, "--port", port}
+	for _, a := range args {
+		if strings.ContainsAny(a, " \t\"'$\\*?") {
+			a = fmt.Sprintf("%q", a)
+		}
+		cmd = append(cmd, a)
+	}
+	log.Printf("To resume, run: %s", strings.Join(cmd, " "))
+}
@@ -6,9 +6,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"golang.org/x/net/context"
 	"google.golang.org/appengine/remote_api"
 
 	"github.com/ronoaldo/aetools"
@@ -41,67 +46,336 @@ var (
 	dump      string                // Kind to export
 	key       string                // Key of entity to export
 	load      = make(StringList, 0) // StringList to load data into.
+	dumpDir   string                // Directory to export, one file per entity.
+	loadDir   = make(StringList, 0) // Directories to load data from.
 	batchSize int                   // Size for batch operations.
 	pretty    bool                  // Pretty print the JSON output.
+	canonical bool                  // Use the canonical JSON output.
+	keepGoing bool                  // Continue loading after errors.
+	workers   int                   // Batches to load concurrently.
+	serialize bool                  // Don't load an entity group concurrently.
+	maxQPS    float64               // Datastore calls per second.
+	maxEPS    float64               // Entities per second.
+	retries   int                   // Retries after timeouts and contention.
+	skip      int                   // Entities to skip when loading.
+	dumpIndex string                // Search index to export.
+	loadIndex = make(StringList, 0) // Search index fixtures to load.
+	start     string                // Cursor to start dumping.
+
+	showProgress bool // Show a progress line on stderr.
 )
 
 func init() {
 	flag.StringVar(&host, "host", "localhost", "The server to connect")
 	flag.StringVar(&port, "port", "8888", "The port to connect")
 	flag.BoolVar(&debug, "debug", false, "Display debug information")
-	flag.StringVar(&dump, "dump", "", "Datastore kind to export, ignored when loading")
-	flag.StringVar(&key, "dump-entity", "", "Key String of entity to export")
-	flag.Var(&load, "load", "Fixture files to import, ignored when dumping")
-	flag.IntVar(&batchSize, "batch-size", 50, "Size for batch operations")
-	flag.BoolVar(&pretty, "pretty", false, "Pretty print the JSON output")
+
+	// Flags of the original command line, kept as aliases of the
+	// dump, load and get commands.
+	flag.StringVar(&dump, "dump", "", "Datastore kind to export, ignored when loading (same as the dump command)")
+	flag.StringVar(&key, "dump-entity", "", "Key of entity to export, as an encoded key, a key path like User,123 or a JSON key like [\"User\",123] (same as the get command)")
+	flag.Var(&load, "load", "Fixture files to import, ignored when dumping (same as the load command)")
+	flag.Var(&loadDir, "load-dir", "Directories with fixture files to import, ignored when dumping (same as the load command)")
+	flag.StringVar(&dumpIndex, "dump-index", "", "Search API index to export")
+	flag.Var(&loadIndex, "load-index", "Search API documents to load, as INDEX=FILE or FILE, using the file name without extension as the index name")
+	flag.StringVar(&dumpDir, "dump-dir", "", "Directory to export the --dump kind, with one file per entity")
+	outputFlags(flag.CommandLine)
+	dumpFlags(flag.CommandLine)
+	loadFlags(flag.CommandLine)
+	batchFlags(flag.CommandLine)
+	throttleFlags(flag.CommandLine)
+	progressFlag(flag.CommandLine)
 }
 
-func main() {
-	flag.Parse()
+// The functions below define the flags shared by the original command
+// line and the commands in a flag set, bound to the same variables.
+
+// outputFlags defines the flags of the JSON output.
+func outputFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&pretty, "pretty", false, "Pretty print the JSON output")
+	fs.BoolVar(&canonical, "canonical", false, "Use the canonical JSON output, suitable for SCM checkin")
+}
+
+// dumpFlags defines the flags used to dump entities.
+func dumpFlags(fs *flag.FlagSet) {
+	fs.StringVar(&start, "start", "", "Query cursor to start the dump, to resume an interrupted dump")
+}
+
+// loadFlags defines the flags used to load entities.
+func loadFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&keepGoing, "continue-on-error", false, "Load all valid entities, reporting the failed ones, instead of stopping at the first error")
+	fs.IntVar(&workers, "workers", 1, "Number of batches to load concurrently")
+	fs.BoolVar(&serialize, "serialize-groups", false, "Never load entities of the same entity group concurrently")
+	fs.IntVar(&skip, "skip", 0, "Number of entities to skip at the start of each fixture, to resume an interrupted load")
+}
+
+// batchFlags defines the batch size flag.
+func batchFlags(fs *flag.FlagSet) {
+	fs.IntVar(&batchSize, "batch-size", 50, "Size for batch operations")
+}
+
+// throttleFlags defines the rate limits and retries flags.
+func throttleFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&maxQPS, "max-qps", 0, "Maximum datastore calls per second when dumping or loading, 0 for no limit")
+	fs.Float64Var(&maxEPS, "max-entities-per-second", 0, "Maximum entities per second when dumping or loading, 0 for no limit")
+	fs.IntVar(&retries, "retries", 5, "Number of retries of a batch after datastore timeouts and contention")
+}
+
+// progressFlag defines the --progress flag.
+func progressFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&showProgress, "progress", true, "Show the progress of dumps and loads on stderr, when it is a terminal")
+}
+
+// Exit codes of aeremote.
+const (
+	exitOK          = 0   // The command succeeded.
+	exitError       = 1   // The command failed.
+	exitUsage       = 2   // Invalid command line.
+	exitIncomplete  = 3   // Some entities or keys were not processed.
+	exitInterrupted = 130 // Interrupted by a signal; a resume hint is logged.
+)
+
+// usageError is returned by commands called with invalid arguments.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// incompleteError is returned by commands that finished, but could not
+// process some of the entities or keys given.
+type incompleteError string
+
+func (e incompleteError) Error() string { return string(e) }
+
+// exitCode returns the exit code for the error returned by a command.
+func exitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return exitOK
+	case usageError:
+		return exitUsage
+	case incompleteError:
+		return exitIncomplete
+	case *aetools.InterruptedError:
+		return exitInterrupted
+	}
+	return exitError
+}
+
+// command is an aeremote operation invoked by name, after the global flags:
+//
+//	aeremote -host localhost gen-structs --package models MyKind
+type command struct {
+	// Name is the command name used in the command line.
+	Name string
+	// Args is the synopsis of the command arguments, like "KIND...".
+	Args string
+	// Usage is a short help message for the command.
+	Usage string
+	// Help is the detailed help message displayed by "aeremote help NAME"
+	// and by the -h flag of the command.
+	Help string
+	// Flags are the command specific flags.
+	Flags *flag.FlagSet
+	// Run executes the command with the remaining non-flag arguments.
+	Run func(args []string) error
+}
+
+// commands holds all registered commands by name.
+var commands = make(map[string]*command)
+
+// register adds cmd to the list of available commands.
+func register(cmd *command) {
+	commands[cmd.Name] = cmd
+	cmd.Flags.Usage = func() { commandUsage(os.Stderr, cmd) }
+}
+
+// commandUsage writes the help of cmd to w.
+func commandUsage(w io.Writer, cmd *command) {
+	fmt.Fprintf(w, "Usage: aeremote [global flags] %s [flags] %s\n\n%s.\n", cmd.Name, cmd.Args, cmd.Usage)
+	if cmd.Help != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(cmd.Help))
+	}
+	n := 0
+	cmd.Flags.VisitAll(func(*flag.Flag) { n++ })
+	if n > 0 {
+		fmt.Fprintf(w, "\nFlags:\n")
+		cmd.Flags.SetOutput(w)
+		cmd.Flags.PrintDefaults()
+		cmd.Flags.SetOutput(nil)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: aeremote [global flags] command [command flags] [args]\n\nGlobal flags:\n")
+	flag.PrintDefaults()
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, n := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n\t%s\n", n, commands[n].Usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"aeremote help COMMAND\" for the help of a command.\n")
+}
+
+func init() {
+	register(&command{
+		Name:  "help",
+		Args:  "[COMMAND]",
+		Usage: "Show the help of a command, or the list of commands",
+		Flags: flag.NewFlagSet("help", flag.ExitOnError),
+		Run: func(args []string) error {
+			if len(args) == 0 {
+				usage()
+				return nil
+			}
+			cmd, ok := commands[args[0]]
+			if !ok {
+				return usageError(fmt.Sprintf("help: unknown command %s", args[0]))
+			}
+			commandUsage(os.Stdout, cmd)
+			return nil
+		},
+	})
+}
 
+// subcommand returns the name and arguments of the subcommand in args,
+// parsing the flags given after the name with fs, so they can be used
+// before or after it. The valid names are used in the error messages.
+func subcommand(fs *flag.FlagSet, args []string, names string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, usageError(fmt.Sprintf("%s: missing subcommand: %s", fs.Name(), names))
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", nil, usageError(err.Error())
+	}
+	return args[0], fs.Args(), nil
+}
+
+// remote is the Remote API context shared by all commands.
+var remote context.Context
+
+// remoteContext connects to the configured host and port,
+// returning a Remote API context. The connection is reused
+// by subsequent calls.
+func remoteContext() (context.Context, error) {
+	if remote != nil {
+		return remote, nil
+	}
 	client, err := newClient()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
 	c, err := remote_api.NewRemoteContext(host, client)
 	if err != nil {
-		log.Fatalf("Error loading RemoteContext: %s", err.Error())
+		return nil, fmt.Errorf("Error loading RemoteContext: %s", err.Error())
+	}
+	remote = c
+	return c, nil
+}
+
+// loadOptions returns the options used to load entities.
+func loadOptions() *aetools.Options {
+	return throttle(&aetools.Options{
+		BatchSize:       batchSize,
+		Workers:         workers,
+		SerializeGroups: serialize,
+		ContinueOnError: keepGoing,
+		Skip:            skip,
+	})
+}
+
+// dumpOptions returns the options used to dump the given kind.
+func dumpOptions(kind string) *aetools.Options {
+	return throttle(&aetools.Options{Kind: kind, PrettyPrint: pretty, Canonical: canonical, BatchSize: batchSize, Start: start})
+}
+
+// throttle sets the rate limits and retries of o from the command line.
+func throttle(o *aetools.Options) *aetools.Options {
+	o.MaxQPS, o.MaxEntitiesPerSecond, o.Retries = maxQPS, maxEPS, retries
+	return o
+}
+
+// indexFixture returns the index name and file name of a --load-index
+// fixture, given as INDEX=FILE or FILE.
+func indexFixture(s string) (string, string) {
+	if i := strings.Index(s, "="); i > 0 {
+		return s[:i], s[i+1:]
 	}
+	name := filepath.Base(s)
+	return strings.TrimSuffix(name, filepath.Ext(name)), s
+}
 
+// reportLoad logs the result of loading the fixture name, returning
+// false if any entity was not loaded.
+func reportLoad(name string, r *aetools.LoadResult, err error) bool {
+	log.Printf("Loaded %s: %d entities read, %d written, %d skipped, %d failed in %v",
+		name, r.Read, r.Written, r.Skipped, len(r.Failed), r.Duration)
+	for _, f := range r.Failed {
+		log.Printf("  %v", f)
+	}
+	if err != nil && len(r.Failed) == 0 {
+		log.Printf("Error loading %s: %v", name, err)
+	}
+	return err == nil
+}
+
+// run executes the command name with args, parsing its flags.
+func run(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown command %s", name))
+	}
+	if err := cmd.Flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return usageError(err.Error())
+	}
+	return cmd.Run(cmd.Flags.Args())
+}
+
+// legacyCommand returns the command and arguments equivalent to the
+// original command line flags, used when no command is given.
+func legacyCommand() (string, []string) {
 	switch {
 	case dump != "":
-		log.Printf("Dumping entities of kind %s...\n", dump)
-		err = aetools.Dump(c, os.Stdout, &aetools.Options{Kind: dump, PrettyPrint: pretty, BatchSize: batchSize})
-		if err != nil {
-			log.Fatal(err)
-		}
-	case len(load) > 0:
-		log.Println("Loading entities ...")
-		for _, f := range load {
-			fd, err := os.Open(f)
-			if err != nil {
-				log.Printf("Error opening %s\n", err.Error())
-				continue
-			}
-			err = aetools.Load(c, fd, &aetools.Options{
-				BatchSize: batchSize,
-			})
-			if err != nil {
-				log.Printf("Error loading fixture %s: %s\n", f, err.Error())
-			}
-			fd.Close()
-		}
+		return "dump", []string{dump}
+	case dumpIndex != "":
+		return "dump-index", []string{dumpIndex}
+	case len(loadIndex) > 0:
+		return "load-index", loadIndex
+	case len(load) > 0 || len(loadDir) > 0:
+		return "load", append(append([]string{}, load...), loadDir...)
 	case key != "":
-		log.Printf("Dumping entity key %s\n", key)
-		err = aetools.DumpEntity(c, os.Stdout, key, &aetools.Options{Kind: dump, PrettyPrint: pretty, BatchSize: batchSize})
-		if err != nil {
-			log.Fatal(err)
-		}
-	default:
-		err = aetools.Dump(c, os.Stdout, &aetools.Options{Kind: StatKind, PrettyPrint: true, BatchSize: batchSize})
-		if err != nil {
-			log.Fatal(err)
-		}
+		return "get", []string{key}
+	}
+	return "stats", nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		// The flags of the equivalent command were already parsed
+		name, args := legacyCommand()
+		exit(commands[name].Run(args))
+	}
+	if _, ok := commands[flag.Arg(0)]; !ok {
+		log.Printf("Unknown command %s", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	exit(run(flag.Arg(0), flag.Args()[1:]))
+}
+
+// exit terminates aeremote with the exit code of err, logging it.
+func exit(err error) {
+	if err != nil {
+		log.Print(err)
 	}
+	os.Exit(exitCode(err))
 }
@@ -0,0 +1,268 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/memcache"
+)
+
+// memcacheItem is the JSON representation of a memcache item, used by the
+// memcache command. Values that are compact JSON are used as is, unless
+// they are objects with a "type" attribute or have characters that are
+// escaped by json.Marshal, like < and &. Other values use an object with
+// the "type" attribute set to "string" or, if they are not valid UTF-8, to
+// "blob" with the base64 encoded value, like the typed properties of the
+// entity format.
+type memcacheItem struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	Flags uint32          `json:"flags,omitempty"`
+	// Expiration is the item expiration in seconds, used by set.
+	// Zero means no expiration.
+	Expiration int64 `json:"expiration,omitempty"`
+}
+
+// typedValue is a memcache value that is not used as is.
+type typedValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+var memcacheFlags = struct {
+	namespace string
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("memcache", flag.ExitOnError)
+	fs.StringVar(&memcacheFlags.namespace, "namespace", "", "Namespace of the memcache keys")
+	register(&command{
+		Name:  "memcache",
+		Usage: "Inspect or change memcache values, in JSON: memcache get KEY... | set FILE | delete KEY... | flush | stats",
+		Flags: fs,
+		Run: func(args []string) error {
+			cmd, args, err := subcommand(fs, args, "get, set, delete, flush or stats")
+			if err != nil {
+				return err
+			}
+			return runMemcache(cmd, args)
+		},
+	})
+}
+
+func runMemcache(cmd string, args []string) error {
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	if memcacheFlags.namespace != "" {
+		if c, err = appengine.Namespace(c, memcacheFlags.namespace); err != nil {
+			return err
+		}
+	}
+	switch cmd {
+	case "get":
+		return memcacheGet(c, os.Stdout, args)
+	case "set":
+		if len(args) != 1 {
+			return usageError("memcache: set needs one file name, or - for the standard input")
+		}
+		return memcacheSet(c, args[0])
+	case "delete":
+		return memcacheDelete(c, args)
+	case "flush":
+		return memcache.Flush(c)
+	case "stats":
+		return memcacheStats(c, os.Stdout)
+	default:
+		return usageError(fmt.Sprintf("memcache: unknown subcommand %s", cmd))
+	}
+}
+
+// memcacheGet writes the items with the given keys as a JSON array, in
+// the format accepted by set. Missing keys are reported as an error.
+func memcacheGet(c context.Context, w io.Writer, keys []string) error {
+	if len(keys) == 0 {
+		return usageError("memcache: get needs at least one key")
+	}
+	found, err := memcache.GetMulti(c, keys)
+	if err != nil {
+		return err
+	}
+	items := make([]*memcacheItem, 0, len(found))
+	missing := 0
+	for _, k := range keys {
+		it, ok := found[k]
+		if !ok {
+			log.Printf("Key %s not found", k)
+			missing++
+			continue
+		}
+		items = append(items, &memcacheItem{Key: it.Key, Value: encodeMemcacheValue(it.Value), Flags: it.Flags})
+	}
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return incompleteError(fmt.Sprintf("memcache: %d of %d keys not found", missing, len(keys)))
+	}
+	return nil
+}
+
+// memcacheSet saves the items read from the JSON array in file.
+func memcacheSet(c context.Context, file string) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		r = fd
+	}
+	var items []*memcacheItem
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return fmt.Errorf("memcache: invalid items file %s: %v", file, err)
+	}
+	l := make([]*memcache.Item, 0, len(items))
+	for i, it := range items {
+		if it.Key == "" {
+			return fmt.Errorf("memcache: item %d has no key", i)
+		}
+		v, err := decodeMemcacheValue(it.Value)
+		if err != nil {
+			return fmt.Errorf("memcache: item %s: %v", it.Key, err)
+		}
+		l = append(l, &memcache.Item{
+			Key:        it.Key,
+			Value:      v,
+			Flags:      it.Flags,
+			Expiration: time.Duration(it.Expiration) * time.Second,
+		})
+	}
+	if err := memcache.SetMulti(c, l); err != nil {
+		return err
+	}
+	log.Printf("Saved %d items", len(l))
+	return nil
+}
+
+// memcacheDelete removes the given keys. Missing keys are reported as
+// an error, after all keys are deleted.
+func memcacheDelete(c context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return usageError("memcache: delete needs at least one key")
+	}
+	err := memcache.DeleteMulti(c, keys)
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		return err
+	}
+	missing := 0
+	for i, err := range me {
+		switch err {
+		case nil:
+		case memcache.ErrCacheMiss:
+			log.Printf("Key %s not found", keys[i])
+			missing++
+		default:
+			return fmt.Errorf("memcache: error deleting %s: %v", keys[i], err)
+		}
+	}
+	if missing > 0 {
+		return incompleteError(fmt.Sprintf("memcache: %d of %d keys not found", missing, len(keys)))
+	}
+	return nil
+}
+
+// memcacheStats writes the memcache statistics as JSON.
+func memcacheStats(c context.Context, w io.Writer) error {
+	s, err := memcache.Stats(c)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{
+		"hits":      s.Hits,
+		"misses":    s.Misses,
+		"byte_hits": s.ByteHits,
+		"items":     s.Items,
+		"bytes":     s.Bytes,
+		"oldest":    s.Oldest,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// encodeMemcacheValue returns the JSON representation of the value v.
+func encodeMemcacheValue(v []byte) json.RawMessage {
+	if isRawValue(v) {
+		return json.RawMessage(v)
+	}
+	t := typedValue{Type: "string", Value: string(v)}
+	if !utf8.Valid(v) {
+		t = typedValue{Type: "blob", Value: base64.URLEncoding.EncodeToString(v)}
+	}
+	m, _ := json.Marshal(t)
+	return json.RawMessage(m)
+}
+
+// decodeMemcacheValue returns the value represented by the JSON in m.
+func decodeMemcacheValue(m json.RawMessage) ([]byte, error) {
+	if len(m) == 0 {
+		return nil, fmt.Errorf("missing value")
+	}
+	var t typedValue
+	if bytes.HasPrefix(bytes.TrimSpace(m), []byte("{")) && json.Unmarshal(m, &t) == nil {
+		switch t.Type {
+		case "string":
+			return []byte(t.Value), nil
+		case "blob":
+			return base64.URLEncoding.DecodeString(t.Value)
+		}
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// isRawValue returns true if v can be written as is, so it is decoded
+// back to the same bytes: v must be compact JSON, and not an object with
+// a "type" attribute, that is read as a typedValue. Values with the
+// characters escaped by json.Marshal in embedded JSON are not used as is.
+func isRawValue(v []byte) bool {
+	var b bytes.Buffer
+	if len(v) == 0 || json.Compact(&b, v) != nil || !bytes.Equal(b.Bytes(), v) {
+		return false
+	}
+	if bytes.ContainsAny(v, "<>&\u2028\u2029") {
+		return false
+	}
+	var m map[string]json.RawMessage
+	if json.Unmarshal(v, &m) == nil {
+		_, typed := m["type"]
+		return !typed
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMemcacheValueRoundTrip(t *testing.T) {
+	values := []string{
+		`{"a":1}`,
+		`{"a": 1}`,
+		`[1,2]`,
+		`123`,
+		`null`,
+		` 1`,
+		``,
+		`hello`,
+		`{"type":"string","value":"x"}`,
+		`{"url":"a&b<c>"}`,
+		"\"line\u2028sep\"",
+		"\xff\xfe\xfd",
+	}
+	for _, v := range values {
+		// Encode like memcache get, and decode like memcache set
+		b, err := json.MarshalIndent([]*memcacheItem{{Key: "k", Value: encodeMemcacheValue([]byte(v))}}, "", "  ")
+		if err != nil {
+			t.Fatalf("Error encoding %q: %v", v, err)
+		}
+		var items []*memcacheItem
+		if err := json.Unmarshal(b, &items); err != nil {
+			t.Fatalf("Error decoding %q from %s: %v", v, b, err)
+		}
+		d, err := decodeMemcacheValue(items[0].Value)
+		if err != nil {
+			t.Errorf("Error decoding %q from %s: %v", v, b, err)
+			continue
+		}
+		if string(d) != v {
+			t.Errorf("Unexpected value %q, expected %q, from %s", d, v, b)
+		}
+	}
+	if m := encodeMemcacheValue([]byte(`{"a":[1,"x"]}`)); string(m) != `{"a":[1,"x"]}` {
+		t.Errorf("Unexpected encoding of compact JSON: %s", m)
+	}
+}
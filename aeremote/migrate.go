@@ -0,0 +1,27 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"flag"
+
+	"github.com/ronoaldo/aetools/migrate"
+)
+
+func init() {
+	register(&command{
+		Name:  "migrate",
+		Usage: "Run the registered data migrations: migrate up [--dry-run] [--to ID] [--batch-size N] | migrate status",
+		Flags: flag.NewFlagSet("migrate", flag.ExitOnError),
+		Run:   runMigrate,
+	})
+}
+
+func runMigrate(args []string) error {
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	return migrate.Main(c, args)
+}
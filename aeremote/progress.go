@@ -0,0 +1,70 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// withProgress sets o.Progress to render the progress of name as a single
+// line on stderr, updated after each batch. It returns o and a function to
+// finish the line. Nothing is shown when stderr is not a terminal or
+// --progress=false is used.
+func withProgress(name string, o *aetools.Options) (*aetools.Options, func()) {
+	if !showProgress || !isTerminal(os.Stderr) {
+		return o, func() {}
+	}
+	printed := false
+	o.Progress = func(s aetools.Stats) {
+		printed = true
+		fmt.Fprintf(os.Stderr, "\r%s: %s\x1b[K", name, formatStats(s))
+	}
+	return o, func() {
+		if printed {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// formatStats returns a one line summary of s.
+func formatStats(s aetools.Stats) string {
+	entities := fmt.Sprintf("%d entities", s.Entities)
+	if s.Total > 0 {
+		entities = fmt.Sprintf("%d/%d entities (%d%%)", s.Entities, s.Total, s.Entities*100/s.Total)
+	}
+	line := fmt.Sprintf("%s, %s, %d batches, %v elapsed", entities, formatBytes(s.Bytes), s.Batches, truncate(s.Elapsed))
+	if s.ETA > 0 {
+		line += fmt.Sprintf(", ETA %v", truncate(s.ETA))
+	}
+	return line
+}
+
+// truncate returns d in whole seconds.
+func truncate(d time.Duration) time.Duration {
+	return d - d%time.Second
+}
+
+// formatBytes returns n in the largest binary unit below it.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal reports if f is a character device, like a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,150 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func init() {
+	register(&command{
+		Name:  "shell",
+		Usage: "Run commands interactively, reusing the same connection",
+		Help: `
+Each line read from the standard input is a command with its flags and
+arguments, quoted like in a POSIX shell. Errors are reported and the shell
+keeps running; use "exit" or end of file to quit. Flags given to a command
+are reset before the next one, to the values given on the aeremote command
+line.`,
+		Flags: flag.NewFlagSet("shell", flag.ExitOnError),
+		Run:   runShell,
+	})
+}
+
+func runShell(args []string) error {
+	if len(args) > 0 {
+		return usageError("shell: takes no arguments")
+	}
+	if _, err := remoteContext(); err != nil {
+		return err
+	}
+	var restore []func()
+	for _, cmd := range commands {
+		cmd.Flags.Init(cmd.Name, flag.ContinueOnError)
+		restore = append(restore, saveFlags(cmd.Flags))
+	}
+	prompt := fmt.Sprintf("%s:%s> ", host, port)
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		if !in.Scan() {
+			fmt.Fprintln(os.Stderr)
+			return in.Err()
+		}
+		line, err := splitArgs(in.Text())
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case "exit", "quit":
+			return nil
+		case "shell":
+			log.Printf("Already in the shell")
+			continue
+		}
+		if err := run(line[0], line[1:]); err != nil {
+			log.Printf("%v (exit code %d)", err, exitCode(err))
+		}
+		for _, f := range restore {
+			f()
+		}
+	}
+}
+
+// saveFlags returns a function that restores the current values of
+// the flags in fs.
+func saveFlags(fs *flag.FlagSet) func() {
+	var restore []func()
+	fs.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*StringList); ok {
+			saved := append(StringList{}, *l...)
+			restore = append(restore, func() { *l = append(StringList{}, saved...) })
+			return
+		}
+		saved := f.Value.String()
+		restore = append(restore, func() { f.Value.Set(saved) })
+	})
+	return func() {
+		for _, f := range restore {
+			f()
+		}
+	}
+}
+
+// splitArgs splits line into words separated by spaces. Single quotes
+// preserve the enclosed text, and double quotes preserve it except for
+// backslash escapes, like in a POSIX shell.
+func splitArgs(line string) ([]string, error) {
+	var (
+		args  []string
+		word  []rune
+		quote rune
+		inArg bool
+	)
+	r := []rune(line)
+	for i := 0; i < len(r); i++ {
+		ch := r[i]
+		switch {
+		case quote == '\'':
+			if ch == '\'' {
+				quote = 0
+			} else {
+				word = append(word, ch)
+			}
+		case ch == '\\' && quote == '"':
+			if i+1 < len(r) && strings.ContainsRune("\"\\$`", r[i+1]) {
+				i++
+				ch = r[i]
+			}
+			word = append(word, ch)
+		case quote == '"':
+			if ch == '"' {
+				quote = 0
+			} else {
+				word = append(word, ch)
+			}
+		case ch == '\\':
+			if i+1 == len(r) {
+				return nil, fmt.Errorf("shell: trailing backslash")
+			}
+			i++
+			word, inArg = append(word, r[i]), true
+		case ch == '\'' || ch == '"':
+			quote, inArg = ch, true
+		case ch == ' ' || ch == '\t':
+			if inArg {
+				args = append(args, string(word))
+				word, inArg = word[:0], false
+			}
+		default:
+			word, inArg = append(word, ch), true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("shell: unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, string(word))
+	}
+	return args, nil
+}
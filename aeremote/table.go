@@ -0,0 +1,68 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// formatTable is the output format of aligned text columns, for reading.
+const formatTable = "table"
+
+// maxCellWidth is the maximum number of characters in a table cell.
+const maxCellWidth = 40
+
+// writeTable writes entities to w as a text table, with the "__key__"
+// column followed by the given columns, or by all properties in name
+// order if columns is empty. Cells have the values of the CSV format,
+// truncated to maxCellWidth characters.
+func writeTable(w io.Writer, entities []aetools.Entity, columns []string) error {
+	var b bytes.Buffer
+	if err := aetools.WriteEntities(&b, entities, aetools.FormatCSV, nil); err != nil {
+		return err
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	header := rows[0]
+	if len(columns) > 0 {
+		header = append([]string{"__key__"}, columns...)
+	}
+	index := make(map[string]int)
+	for i, n := range rows[0] {
+		index[n] = i
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows[1:] {
+		cells := make([]string, len(header))
+		for i, n := range header {
+			if j, ok := index[n]; ok {
+				cells[i] = cell(row[j])
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// cell returns s in a single line, truncated to maxCellWidth characters.
+func cell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxCellWidth {
+		return string(r[:maxCellWidth-3]) + "..."
+	}
+	return s
+}
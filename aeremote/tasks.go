@@ -0,0 +1,206 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// maxTasksPerCall is the maximum number of tasks added with one call.
+const maxTasksPerCall = 100
+
+// taskSpec is the JSON representation of a task read by tasks add.
+type taskSpec struct {
+	// Path is the request path, required.
+	Path string `json:"path"`
+	// Method is the HTTP method, defaults to POST.
+	Method string `json:"method"`
+	// Payload is the request body.
+	Payload string `json:"payload"`
+	// Params are form values, sent as the body of POST and PUT
+	// requests or in the query string of other methods.
+	Params map[string]string `json:"params"`
+	// Headers are the request headers.
+	Headers map[string]string `json:"headers"`
+	// ETA is the time to run the task, in the RFC3339 format.
+	ETA time.Time `json:"eta"`
+	// Name is an optional task name.
+	Name string `json:"name"`
+}
+
+// task returns the taskqueue.Task for t.
+func (t *taskSpec) task() (*taskqueue.Task, error) {
+	if !strings.HasPrefix(t.Path, "/") {
+		return nil, fmt.Errorf("invalid path %q", t.Path)
+	}
+	task := &taskqueue.Task{
+		Path:    t.Path,
+		Method:  strings.ToUpper(t.Method),
+		Payload: []byte(t.Payload),
+		Header:  make(http.Header),
+		ETA:     t.ETA,
+		Name:    t.Name,
+	}
+	if task.Method == "" {
+		task.Method = "POST"
+	}
+	for k, v := range t.Headers {
+		task.Header.Set(k, v)
+	}
+	if len(t.Params) > 0 {
+		params := make(url.Values)
+		for k, v := range t.Params {
+			params.Set(k, v)
+		}
+		switch task.Method {
+		case "POST", "PUT":
+			if len(task.Payload) > 0 {
+				return nil, fmt.Errorf("task %s has both params and payload", t.Path)
+			}
+			task.Payload = []byte(params.Encode())
+			if task.Header.Get("Content-Type") == "" {
+				task.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		default:
+			sep := "?"
+			if strings.Contains(task.Path, "?") {
+				sep = "&"
+			}
+			task.Path += sep + params.Encode()
+		}
+	}
+	return task, nil
+}
+
+var tasksFlags = struct {
+	queue string
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("tasks", flag.ExitOnError)
+	fs.StringVar(&tasksFlags.queue, "queue", "", "Name of the task queue, required by purge (default \"default\")")
+	register(&command{
+		Name:  "tasks",
+		Usage: "Add tasks from a JSON file, purge or show a queue: tasks add [--queue Q] FILE | purge --queue Q | stats [--queue Q]",
+		Flags: fs,
+		Run: func(args []string) error {
+			cmd, args, err := subcommand(fs, args, "add, purge or stats")
+			if err != nil {
+				return err
+			}
+			return runTasks(cmd, args)
+		},
+	})
+}
+
+func runTasks(cmd string, args []string) error {
+	queue := tasksFlags.queue
+	if queue == "" && cmd != "purge" {
+		queue = "default"
+	}
+	switch cmd {
+	case "add":
+		if len(args) != 1 {
+			return usageError("tasks: add needs one file name, or - for the standard input")
+		}
+		c, err := remoteContext()
+		if err != nil {
+			return err
+		}
+		return addTasks(c, args[0], queue)
+	case "purge":
+		if queue == "" {
+			return usageError("tasks: purge needs the --queue to empty")
+		}
+		c, err := remoteContext()
+		if err != nil {
+			return err
+		}
+		if err := taskqueue.Purge(c, queue); err != nil {
+			return err
+		}
+		log.Printf("Purged queue %s", queue)
+		return nil
+	case "stats":
+		c, err := remoteContext()
+		if err != nil {
+			return err
+		}
+		return queueStats(c, os.Stdout, queue)
+	}
+	return usageError(fmt.Sprintf("tasks: unknown subcommand %s", cmd))
+}
+
+// addTasks adds the tasks read from the JSON array in file to queue.
+func addTasks(c context.Context, file, queue string) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		r = fd
+	}
+	var specs []*taskSpec
+	if err := json.NewDecoder(r).Decode(&specs); err != nil {
+		return fmt.Errorf("tasks: invalid tasks file %s: %v", file, err)
+	}
+	tasks := make([]*taskqueue.Task, 0, len(specs))
+	for i, s := range specs {
+		t, err := s.task()
+		if err != nil {
+			return fmt.Errorf("tasks: task %d: %v", i, err)
+		}
+		tasks = append(tasks, t)
+	}
+	for start := 0; start < len(tasks); start += maxTasksPerCall {
+		end := start + maxTasksPerCall
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		if _, err := taskqueue.AddMulti(c, tasks[start:end], queue); err != nil {
+			return fmt.Errorf("tasks: error adding tasks %d to %d: %v", start, end-1, err)
+		}
+	}
+	log.Printf("Added %d tasks to queue %s", len(tasks), queue)
+	return nil
+}
+
+// queueStats writes the statistics of queue as JSON.
+func queueStats(c context.Context, w io.Writer, queue string) error {
+	stats, err := taskqueue.QueueStats(c, []string{queue})
+	if err != nil {
+		return err
+	}
+	s := stats[0]
+	m := map[string]interface{}{
+		"queue":            queue,
+		"tasks":            s.Tasks,
+		"executed_1minute": s.Executed1Minute,
+		"in_flight":        s.InFlight,
+		"enforced_rate":    s.EnforcedRate,
+	}
+	if !s.OldestETA.IsZero() {
+		m["oldest_eta"] = s.OldestETA
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ronoaldo/aetools"
+)
+
+var typeDrift = struct {
+	scan    bool
+	samples int
+}{}
+
+func init() {
+	fs := flag.NewFlagSet("type-drift", flag.ExitOnError)
+	fs.BoolVar(&typeDrift.scan, "scan", false, "Scan all entities, instead of using the datastore statistics")
+	fs.IntVar(&typeDrift.samples, "samples", 5, "Maximum number of example keys displayed per property type, with --scan")
+	register(&command{
+		Name:  "type-drift",
+		Usage: "Report properties of the given kinds, or all kinds, saved with more than one type",
+		Flags: fs,
+		Run:   runTypeDrift,
+	})
+}
+
+func runTypeDrift(kinds []string) error {
+	c, err := remoteContext()
+	if err != nil {
+		return err
+	}
+	if len(kinds) == 0 {
+		if kinds, err = statKinds(c); err != nil {
+			return err
+		}
+	}
+	found := 0
+	for _, kind := range kinds {
+		var drifts []*aetools.TypeDrift
+		if typeDrift.scan {
+			drifts, err = aetools.TypeDriftFromScan(c, kind, typeDrift.samples, &aetools.Options{BatchSize: batchSize})
+		} else {
+			drifts, err = aetools.TypeDriftFromStats(c, kind)
+		}
+		if err != nil {
+			return err
+		}
+		for _, d := range drifts {
+			fmt.Printf("%s:\n", d.Property)
+			for _, pt := range d.Types {
+				fmt.Printf("\t%s: %d", pt.Type, pt.Count)
+				if len(pt.Samples) > 0 {
+					keys := make([]string, len(pt.Samples))
+					for i, k := range pt.Samples {
+						keys[i] = aetools.KeyPath(k)
+					}
+					fmt.Printf(" (e.g. %s)", strings.Join(keys, "; "))
+				}
+				fmt.Println()
+			}
+		}
+		found += len(drifts)
+	}
+	if found > 0 {
+		return fmt.Errorf("type-drift: %d properties with more than one type", found)
+	}
+	return nil
+}
@@ -13,6 +13,8 @@ import (
 
 	"google.golang.org/api/bigquery/v2"
 	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
 )
 
 const (
@@ -60,16 +62,18 @@ func SchemaForKind(c context.Context, kind string) (*bigquery.TableSchema, error
 	// Query for kind stats
 	k = datastore.NewKey(c, StatByKindKind, kind, 0, nil)
 	kindStats = new(StatByKind)
-	err = datastore.Get(c, k, kindStats)
+	err = getStat(c, k, kindStats)
 	if err != nil && !missingFieldErr(err) {
 		return nil, fmt.Errorf("no stats for '%s': %s", kind, err.Error())
 	}
 	// Parse fields
-	q := datastore.NewQuery(StatByPropertyKind).
-		Filter("kind_name =", kind)
-	for it := q.Run(c); ; {
+	q := &aetools.Query{
+		Kind:    StatByPropertyKind,
+		Filters: []aetools.Filter{{Property: "kind_name", Operator: "=", Value: kind}},
+	}
+	for it := aetools.StoreFromContext(c).Run(c, q); ; {
 		s := new(StatByProperty)
-		k, err = it.Next(s)
+		err = nextStat(it, s)
 		if err == datastore.Done {
 			break
 		}
@@ -118,6 +122,24 @@ func SchemaForKind(c context.Context, kind string) (*bigquery.TableSchema, error
 	return &schema, nil
 }
 
+// getStat loads the statistics entity with key k into dst.
+func getStat(c context.Context, k *datastore.Key, dst interface{}) error {
+	e, err := aetools.Get(c, k)
+	if err != nil {
+		return err
+	}
+	return datastore.LoadStruct(dst, e.Properties)
+}
+
+// nextStat loads the next statistics entity from it into dst.
+func nextStat(it aetools.Iterator, dst interface{}) error {
+	var e aetools.Entity
+	if _, err := it.Next(&e); err != nil {
+		return err
+	}
+	return datastore.LoadStruct(dst, e.Properties)
+}
+
 // byName implements the sort.Interface ordering schema fields by name.
 type byName []*bigquery.TableFieldSchema
 
@@ -10,7 +10,6 @@ import (
 	"github.com/ronoaldo/aetools"
 	"github.com/ronoaldo/aetools/bigquerysync"
 
-	"google.golang.org/appengine/aetest"
 	"google.golang.org/appengine/datastore"
 )
 
@@ -66,13 +65,10 @@ var datastoreStats = `
 }]`
 
 func TestDecodeStatByProperty(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
-	err = aetools.LoadJSON(c, datastoreStats, aetools.LoadSync)
+	err := aetools.LoadJSON(c, datastoreStats, aetools.LoadSync)
 	if err != nil {
 		t.Log("Unable to load fixtures")
 		t.Fatal(err)
@@ -81,7 +77,10 @@ func TestDecodeStatByProperty(t *testing.T) {
 	p := new(bigquerysync.StatByProperty)
 	name := "Date/Time_CreationDate_Account"
 	k := datastore.NewKey(c, bigquerysync.StatByPropertyKind, name, 0, nil)
-	err = datastore.Get(c, k, p)
+	e, err := aetools.Get(c, k)
+	if err == nil {
+		err = datastore.LoadStruct(p, e.Properties)
+	}
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,12 +109,9 @@ func TestDecodeStatByProperty(t *testing.T) {
 }
 
 func TestInferTableSchema(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
-	err = aetools.LoadJSON(c, datastoreStats, aetools.LoadSync)
+	err := aetools.LoadJSON(c, datastoreStats, aetools.LoadSync)
 
 	s, err := bigquerysync.SchemaForKind(c, "Account")
 	if err != nil {
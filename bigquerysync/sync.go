@@ -7,8 +7,6 @@ import (
 	"bytes"
 	"fmt"
 	"golang.org/x/net/context"
-	"sort"
-	"strings"
 
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
@@ -22,6 +20,8 @@ const (
 )
 
 var (
+	// ScatterProperty is the property used by KeyRangesForKind to split
+	// the keys of a kind.
 	ScatterProperty = "__scatter__"
 )
 
@@ -65,12 +65,13 @@ func SyncKeyRange(c context.Context, project, dataset string, start, end *datast
 	var (
 		errors = make(Errors, 0)
 		done   = false
-		cur    datastore.Cursor
+		cur    string
 		last   *datastore.Key
 		buff   = make([]*aetools.Entity, 0, BatchSize)
+		s      = aetools.StoreFromContext(c)
 	)
 	q := createQuery(start, end, cur)
-	for it := q.Run(c); ; {
+	for it := s.Run(c, q); ; {
 		e := new(aetools.Entity)
 		// TODO(ronoaldo): make this for loop consume entities
 		// from a goroutine channel, so it is easier to retry and buffer
@@ -81,10 +82,10 @@ func SyncKeyRange(c context.Context, project, dataset string, start, end *datast
 			break
 		}
 		if err != nil {
-			if strings.Contains(err.Error(), "datastore operation timed out") {
+			if aetools.IsTransientError(err) {
 				log.Infof(c, "Continuing from cursor '%s', due to error %s", cur, err.Error())
 				q := createQuery(start, end, cur)
-				it = q.Run(c)
+				it = s.Run(c, q)
 				continue
 			}
 			errors = append(errors, err)
@@ -92,7 +93,7 @@ func SyncKeyRange(c context.Context, project, dataset string, start, end *datast
 			break
 		}
 
-		e.Key, last = key, key
+		last = key
 		buff = append(buff, e)
 
 		cur, err = it.Cursor()
@@ -137,59 +138,9 @@ func SyncKeyRange(c context.Context, project, dataset string, start, end *datast
 	return len(buff), last, nil
 }
 
-type KeyRange struct {
-	Start *datastore.Key
-	End   *datastore.Key
-}
-
-// cmpStr compares two strings returning -1, 0, or 1 if
-// s is less than, equal or grather than other.
-func cmpStr(s, other string) int {
-	if s < other {
-		return -1
-	} else if s > other {
-		return 1
-	}
-	return 0
-}
-
-// cmpInt compares two int64 returning -1, 0, or 1 if i is
-// less than, equal or grather than other.
-func cmpInt(i, other int64) int {
-	if i < other {
-		return -1
-	} else if i > other {
-		return 1
-	}
-	return 0
-}
-
-// cmpKey compares k and other, returning -1, 0 or 1 if k is
-// less than, equal or grather than other. The algorithm doesn't
-// takes into account any ancestors in the two keys. The order
-// of comparision is AppID, Kind, IntID and StringID. Keys with
-// integer identifiers are smaller than string identifiers.
-func cmpKey(k, other *datastore.Key) int {
-	if k == other {
-		return 0
-	}
-	if r := cmpStr(k.AppID(), other.AppID()); r != 0 {
-		return r
-	}
-	if r := cmpStr(k.Kind(), other.Kind()); r != 0 {
-		return r
-	}
-	if k.IntID() != 0 {
-		if other.IntID() == 0 {
-			return -1
-		}
-		return cmpInt(k.IntID(), other.IntID())
-	}
-	if other.IntID() != 0 {
-		return 1
-	}
-	return cmpStr(k.StringID(), other.StringID())
-}
+// KeyRange is an interval of keys of a kind, as returned by
+// aetools.KeyRangesForKind.
+type KeyRange aetools.KeyRange
 
 // KeyPath takes a datastore.Key and decomposes its ancestor path
 // as a slice of keys, where the first ancestor is at position 0.
@@ -205,103 +156,54 @@ func KeyPath(k *datastore.Key) []*datastore.Key {
 
 // CompareKeys compares k and other, returning -1, 0, 1 if k is less than
 // equal or grather than other, taking into account the full ancestor path.
+// It is the same as aetools.CompareKeys.
 func CompareKeys(k, other *datastore.Key) int {
-	if k == other {
-		return 0
-	}
-
-	thisPath := KeyPath(k)
-	otherPath := KeyPath(other)
-
-	for i, thisKey := range thisPath {
-		if i < len(otherPath) {
-			otherKey := otherPath[i]
-			result := cmpKey(thisKey, otherKey)
-			if result != 0 {
-				return result
-			}
-		} else {
-			return 1
-		}
-	}
-
-	if len(otherPath) > len(thisPath) {
-		return -1
-	}
-	return 0
+	return aetools.CompareKeys(k, other)
 }
 
-// byKey implements sort.Interface to sort keys by they path.
-type byKey []*datastore.Key
-
-func (b byKey) Len() int           { return len(b) }
-func (b byKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
-func (b byKey) Less(i, j int) bool { return CompareKeys(b[i], b[j]) == -1 }
-
 // KeyRangesForKind generates a set of KeyRanges, attempting to make them uniformly
-// distributed by using the __scatter__ property implementation.
+// distributed by using the ScatterProperty, with aetools.KeyRangesByProperty.
+// If the ranges can't be computed, the error is logged and a single range
+// from the first key is returned. The result is empty if the kind has no
+// entities.
 func KeyRangesForKind(c context.Context, kind string) []KeyRange {
 	// TODO(ronoaldo): compute rangeLen using datastore statistics
 	rangeLen := 64
-	// Start key is the first entity key
-	sq := datastore.NewQuery(kind).Order("__key__").KeysOnly().Limit(1)
-	it := sq.Run(c)
-	start, err := it.Next(nil)
-	if err != nil || start == nil {
-		// No entities found, return empty range
-		return []KeyRange{}
-	}
-	log.Infof(c, "Found start key %s", start)
-	// Find scatters to build ranges
-	q := datastore.NewQuery(kind).Order(ScatterProperty).KeysOnly().Limit(rangeLen)
-	keys := make([]*datastore.Key, 0, rangeLen)
-	for it := q.Run(c); ; {
-		k, err := it.Next(nil)
-		if err == datastore.Done {
-			break
-		}
+	ranges, err := aetools.KeyRangesByProperty(c, kind, ScatterProperty, rangeLen)
+	if err != nil {
+		log.Errorf(c, "Error computing key ranges for %s: %v", kind, err)
+		// Start key is the first entity key
+		q := &aetools.Query{Kind: kind, Orders: []string{"__key__"}, KeysOnly: true, Limit: 1}
+		start, err := aetools.StoreFromContext(c).Run(c, q).Next(nil)
 		if err != nil {
-			log.Infof(c, "Error iterating over scatters: %s", err.Error())
-			break
+			// No entities found, return empty range
+			return []KeyRange{}
 		}
-		keys = append(keys, k)
-	}
-	// No scatters, single range
-	if len(keys) < 1 {
 		return []KeyRange{KeyRange{start, nil}}
 	}
-	// Sort by keys and build the key ranges, leaving the final range open
-	sort.Sort(byKey(keys))
-	ranges := make([]KeyRange, 0, len(keys))
-	for _, k := range keys {
-		r := KeyRange{start, k}
-		ranges = append(ranges, r)
-		start = k
+	result := make([]KeyRange, len(ranges))
+	for i, r := range ranges {
+		result[i] = KeyRange(r)
 	}
-	ranges = append(ranges, KeyRange{keys[len(keys)-1], nil})
-	return ranges
+	return result
 }
 
 // createQuery builds a range query using start and end. It works
 // for [start,end[, [start,nil] and [start,start] intervals. The
 // returned query is sorted by __key__ and limited to BatchSize.
-func createQuery(start, end *datastore.Key, cur datastore.Cursor) *datastore.Query {
-	q := datastore.NewQuery(start.Kind())
+func createQuery(start, end *datastore.Key, cur string) *aetools.Query {
+	q := &aetools.Query{Kind: start.Kind(), Start: cur}
 
 	if start.Equal(end) {
-		q = q.Filter("__key__ =", start)
+		q.Filters = append(q.Filters, aetools.Filter{Property: "__key__", Operator: "=", Value: start})
 	} else {
-		q = q.Filter("__key__ >=", start)
+		q.Filters = append(q.Filters, aetools.Filter{Property: "__key__", Operator: ">=", Value: start})
 		if end != nil {
-			q = q.Filter("__key__ <", end)
+			q.Filters = append(q.Filters, aetools.Filter{Property: "__key__", Operator: "<", Value: end})
 		}
 	}
 
-	if cur.String() != "" {
-		q = q.Start(cur)
-	}
-
-	q = q.Order("__key__")
+	q.Orders = []string{"__key__"}
 	return q
 }
 
@@ -9,7 +9,6 @@ import (
 	"github.com/ronoaldo/aetools"
 	"github.com/ronoaldo/aetools/bigquerysync"
 
-	"google.golang.org/appengine/aetest"
 	"google.golang.org/appengine/datastore"
 )
 
@@ -92,10 +91,7 @@ func TestSyncInvalidKeyIntervals(t *testing.T) {
 }
 
 func TestKeyRangeForKind(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 	// No entities: empty range
 	ranges := bigquerysync.KeyRangesForKind(c, "RangeTest")
@@ -168,10 +164,7 @@ func TestKeyRangeForKind(t *testing.T) {
 }
 
 func TestCompareKeys(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
 	A1 := datastore.NewKey(c, "A", "", 1, nil)
@@ -5,6 +5,7 @@ package bigquerysync_test
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -43,13 +44,26 @@ func (t *testContext) Close() error {
 	return nil
 }
 
-func SetupEnv(t *testing.T) TestContext {
+var useSDK = flag.Bool("appengine", false, "Run the tests against the App Engine SDK instead of the in-memory store")
+
+// newTestContext returns a context for tests and a cleanup function.
+// By default, the context uses an aetools.MemoryStore; with the
+// -appengine flag the tests run against the App Engine SDK.
+func newTestContext(t *testing.T) (context.Context, func()) {
+	if !*useSDK {
+		return aetools.NewMemoryContext(context.Background()), func() {}
+	}
 	c, clean, err := aetest.NewContext()
 	if err != nil {
 		t.Fatal(err)
 	}
+	return c, clean
+}
+
+func SetupEnv(t *testing.T) TestContext {
+	c, clean := newTestContext(t)
 
-	err = aetools.Load(c, strings.NewReader(SampleEntities), aetools.LoadSync)
+	_, err := aetools.Load(c, strings.NewReader(SampleEntities), aetools.LoadSync)
 	if err != nil {
 		defer clean()
 		t.Fatal(err)
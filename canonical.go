@@ -0,0 +1,106 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Canonical output is the JSON layout used when Options.Canonical is set.
+// It is designed to be checked into source control, so it is deterministic
+// and generates small diffs when entities change:
+//
+//	[
+//	{
+//	  "__key__": ["Profile",123456],
+//	  "birthday": {"indexed":true,"type":"date","value":"1986-07-19T03:00:00Z"},
+//	  "name": "Ronoaldo JLP",
+//	  "tags": ["a","b","c"]
+//	}
+//	]
+//
+// The entity key is aways the first attribute, followed by the properties
+// sorted by name, one per line. Each value is encoded as compact JSON in a
+// single line, and each entity is written in its own block. The same entity
+// data aways generates the same bytes.
+var (
+	canonicalOpen      = []byte("[\n")
+	canonicalSeparator = []byte(",\n")
+	canonicalClose     = []byte("\n]\n")
+	canonicalEmpty     = []byte("[\n]\n")
+)
+
+// MarshalCanonical returns the canonical JSON representation of the entity.
+// See EncodeCanonical for details on the output format.
+func (e *Entity) MarshalCanonical() ([]byte, error) {
+	m, err := e.Map()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(m))
+	for n := range m {
+		if n != "__key__" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{"__key__"}, names...)
+
+	b := new(bytes.Buffer)
+	b.WriteString("{\n")
+	for i, n := range names {
+		name, err := json.Marshal(n)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m[n])
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("  ")
+		b.Write(name)
+		b.WriteString(": ")
+		b.Write(value)
+		if i < len(names)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// EncodeCanonical writes entities to w as a JSON array, using a canonical
+// layout suitable for source control: the "__key__" attribute is aways
+// first, followed by the properties sorted by name, one per line, with
+// each value encoded as compact JSON. Each entity is written in its own
+// block, and the output is byte-identical for the same entity data.
+func EncodeCanonical(w io.Writer, entities []Entity) error {
+	if len(entities) == 0 {
+		_, err := w.Write(canonicalEmpty)
+		return err
+	}
+	if _, err := w.Write(canonicalOpen); err != nil {
+		return err
+	}
+	for i := range entities {
+		if i > 0 {
+			if _, err := w.Write(canonicalSeparator); err != nil {
+				return err
+			}
+		}
+		b, err := entities[i].MarshalCanonical()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(canonicalClose)
+	return err
+}
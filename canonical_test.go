@@ -0,0 +1,56 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestEncodeCanonical(t *testing.T) {
+	c := OfflineContext(context.Background())
+	k := datastore.NewKey(c, "Profile", "", 123456, nil)
+	e := Entity{Key: k}
+	e.Add(datastore.Property{Name: "tags", Value: "b", Multiple: true})
+	e.Add(datastore.Property{Name: "name", Value: "Ronoaldo JLP"})
+	e.Add(datastore.Property{Name: "tags", Value: "a", Multiple: true})
+	e.Add(datastore.Property{Name: "birthday", Value: time.Date(1986, 7, 19, 3, 0, 0, 0, time.UTC)})
+	e.Add(datastore.Property{Name: "bio", Value: "Long text", NoIndex: true})
+	other := Entity{Key: datastore.NewKey(c, "Profile", "", 123457, nil)}
+
+	expected := `[
+{
+  "__key__": ["Profile",123456],
+  "bio": {"indexed":false,"type":"string","value":"Long text"},
+  "birthday": {"indexed":true,"type":"date","value":"1986-07-19T03:00:00Z"},
+  "name": "Ronoaldo JLP",
+  "tags": ["b","a"]
+},
+{
+  "__key__": ["Profile",123457]
+}
+]
+`
+	for i := 0; i < 3; i++ {
+		var w bytes.Buffer
+		if err := EncodeCanonical(&w, []Entity{e, other}); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != expected {
+			t.Errorf("Unexpected canonical output at run %d:\n%s\nexpected:\n%s", i, w.String(), expected)
+		}
+	}
+
+	var w bytes.Buffer
+	if err := EncodeCanonical(&w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "[\n]\n" {
+		t.Errorf("Unexpected canonical output for no entities: %q", w.String())
+	}
+}
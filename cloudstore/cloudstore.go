@@ -0,0 +1,310 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package cloudstore
+
+import (
+	cloud "cloud.google.com/go/datastore"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// New returns an aetools.Store that uses client as the backend.
+func New(client *cloud.Client) aetools.Store {
+	return &store{client: client}
+}
+
+// NewContext returns a copy of parent, usable outside App Engine, that
+// makes the aetools functions use client as the backend.
+// See aetools.OfflineContext for details about the returned context.
+func NewContext(parent context.Context, client *cloud.Client) context.Context {
+	return aetools.WithStore(aetools.OfflineContext(parent), New(client))
+}
+
+// store implements aetools.Store with the Cloud Datastore client.
+type store struct {
+	client *cloud.Client
+}
+
+func (s *store) GetMulti(c context.Context, keys []*datastore.Key, dst []aetools.Entity) error {
+	l := make([]cloud.PropertyList, len(keys))
+	err := convertError(s.client.GetMulti(c, toCloudKeys(keys), l))
+	me, _ := err.(appengine.MultiError)
+	if err != nil && me == nil {
+		return err
+	}
+	for i := range l {
+		if me != nil && me[i] != nil {
+			continue
+		}
+		dst[i].Key = keys[i]
+		dst[i].Properties = fromCloudProperties(c, l[i])
+	}
+	return err
+}
+
+func (s *store) PutMulti(c context.Context, keys []*datastore.Key, src []aetools.Entity) ([]*datastore.Key, error) {
+	l := make([]cloud.PropertyList, len(src))
+	for i := range src {
+		l[i] = toCloudProperties(src[i].Properties)
+	}
+	ck, err := s.client.PutMulti(c, toCloudKeys(keys), l)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return fromCloudKeys(c, ck), nil
+}
+
+func (s *store) DeleteMulti(c context.Context, keys []*datastore.Key) error {
+	return convertError(s.client.DeleteMulti(c, toCloudKeys(keys)))
+}
+
+func (s *store) AllocateIDs(c context.Context, kind string, parent *datastore.Key, n int) ([]*datastore.Key, error) {
+	keys := make([]*cloud.Key, n)
+	for i := range keys {
+		keys[i] = cloud.IncompleteKey(kind, toCloudKey(parent))
+	}
+	keys, err := s.client.AllocateIDs(c, keys)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return fromCloudKeys(c, keys), nil
+}
+
+func (s *store) Run(c context.Context, q *aetools.Query) aetools.Iterator {
+	cq := cloud.NewQuery(q.Kind)
+	if q.Ancestor != nil {
+		cq = cq.Ancestor(toCloudKey(q.Ancestor))
+		if ns := q.Ancestor.Namespace(); ns != "" {
+			cq = cq.Namespace(ns)
+		}
+	}
+	for _, f := range q.Filters {
+		cq = cq.Filter(f.Property+" "+f.Operator, toCloudValue(f.Value))
+	}
+	for _, o := range q.Orders {
+		cq = cq.Order(o)
+	}
+	if q.Limit > 0 {
+		cq = cq.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		cq = cq.Offset(q.Offset)
+	}
+	if q.KeysOnly {
+		cq = cq.KeysOnly()
+	}
+	if q.Start != "" {
+		cur, err := cloud.DecodeCursor(q.Start)
+		if err != nil {
+			return &errIterator{err}
+		}
+		cq = cq.Start(cur)
+	}
+	if q.End != "" {
+		cur, err := cloud.DecodeCursor(q.End)
+		if err != nil {
+			return &errIterator{err}
+		}
+		cq = cq.End(cur)
+	}
+	return &cloudIterator{c: c, it: s.client.Run(c, cq), keysOnly: q.KeysOnly}
+}
+
+// cloudIterator wraps a Cloud Datastore iterator as an aetools.Iterator.
+type cloudIterator struct {
+	c        context.Context
+	it       *cloud.Iterator
+	keysOnly bool
+}
+
+func (i *cloudIterator) Next(e *aetools.Entity) (*datastore.Key, error) {
+	var (
+		l   cloud.PropertyList
+		dst interface{}
+	)
+	if e != nil && !i.keysOnly {
+		dst = &l
+	}
+	ck, err := i.it.Next(dst)
+	if err == iterator.Done {
+		return nil, datastore.Done
+	}
+	if err != nil {
+		return nil, convertError(err)
+	}
+	k := fromCloudKey(i.c, ck)
+	if e != nil {
+		e.Key = k
+		e.Properties = fromCloudProperties(i.c, l)
+	}
+	return k, nil
+}
+
+func (i *cloudIterator) Cursor() (string, error) {
+	cur, err := i.it.Cursor()
+	if err != nil {
+		return "", err
+	}
+	return cur.String(), nil
+}
+
+// errIterator is an aetools.Iterator that aways fails with err.
+type errIterator struct {
+	err error
+}
+
+func (i *errIterator) Next(e *aetools.Entity) (*datastore.Key, error) { return nil, i.err }
+func (i *errIterator) Cursor() (string, error)                         { return "", i.err }
+
+// toCloudKey converts k, including its ancestors, to a Cloud Datastore key.
+func toCloudKey(k *datastore.Key) *cloud.Key {
+	if k == nil {
+		return nil
+	}
+	return &cloud.Key{
+		Kind:      k.Kind(),
+		ID:        k.IntID(),
+		Name:      k.StringID(),
+		Parent:    toCloudKey(k.Parent()),
+		Namespace: k.Namespace(),
+	}
+}
+
+func toCloudKeys(keys []*datastore.Key) []*cloud.Key {
+	r := make([]*cloud.Key, len(keys))
+	for i, k := range keys {
+		r[i] = toCloudKey(k)
+	}
+	return r
+}
+
+// fromCloudKey converts k, including its ancestors, to an App Engine
+// datastore key, using c to build the key.
+func fromCloudKey(c context.Context, k *cloud.Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	parent := fromCloudKey(c, k.Parent)
+	if parent == nil && k.Namespace != "" {
+		if nc, err := appengine.Namespace(c, k.Namespace); err == nil {
+			c = nc
+		}
+	}
+	return datastore.NewKey(c, k.Kind, k.Name, k.ID, parent)
+}
+
+func fromCloudKeys(c context.Context, keys []*cloud.Key) []*datastore.Key {
+	r := make([]*datastore.Key, len(keys))
+	for i, k := range keys {
+		r[i] = fromCloudKey(c, k)
+	}
+	return r
+}
+
+// toCloudProperties converts props to Cloud Datastore properties,
+// grouping the multiple valued properties as array values.
+func toCloudProperties(props datastore.PropertyList) cloud.PropertyList {
+	var (
+		r     = make(cloud.PropertyList, 0, len(props))
+		multi = make(map[string]int)
+	)
+	for _, p := range props {
+		v := toCloudValue(p.Value)
+		if p.Multiple {
+			if i, ok := multi[p.Name]; ok {
+				r[i].Value = append(r[i].Value.([]interface{}), v)
+				continue
+			}
+			multi[p.Name] = len(r)
+			v = []interface{}{v}
+		}
+		r = append(r, cloud.Property{Name: p.Name, Value: v, NoIndex: p.NoIndex})
+	}
+	return r
+}
+
+// toCloudValue converts v to the equivalent Cloud Datastore value.
+func toCloudValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case *datastore.Key:
+		return toCloudKey(v)
+	case appengine.BlobKey:
+		return string(v)
+	case appengine.GeoPoint:
+		return cloud.GeoPoint{Lat: v.Lat, Lng: v.Lng}
+	case *datastore.Entity:
+		return &cloud.Entity{
+			Key:        toCloudKey(v.Key),
+			Properties: toCloudProperties(v.Properties),
+		}
+	}
+	return v
+}
+
+// fromCloudProperties converts props to App Engine datastore properties,
+// expanding array values as multiple valued properties.
+func fromCloudProperties(c context.Context, props cloud.PropertyList) datastore.PropertyList {
+	r := make(datastore.PropertyList, 0, len(props))
+	for _, p := range props {
+		if values, ok := p.Value.([]interface{}); ok {
+			for _, v := range values {
+				r = append(r, datastore.Property{
+					Name:     p.Name,
+					Value:    fromCloudValue(c, v),
+					NoIndex:  p.NoIndex,
+					Multiple: true,
+				})
+			}
+			continue
+		}
+		r = append(r, datastore.Property{
+			Name:    p.Name,
+			Value:   fromCloudValue(c, p.Value),
+			NoIndex: p.NoIndex,
+		})
+	}
+	return r
+}
+
+// fromCloudValue converts v to the equivalent App Engine datastore value.
+func fromCloudValue(c context.Context, v interface{}) interface{} {
+	switch v := v.(type) {
+	case *cloud.Key:
+		return fromCloudKey(c, v)
+	case cloud.GeoPoint:
+		return appengine.GeoPoint{Lat: v.Lat, Lng: v.Lng}
+	case *cloud.Entity:
+		return &datastore.Entity{
+			Key:        fromCloudKey(c, v.Key),
+			Properties: fromCloudProperties(c, v.Properties),
+		}
+	}
+	return v
+}
+
+// convertError converts the Cloud Datastore errors to the App Engine
+// datastore errors, as expected by the aetools.Store users.
+func convertError(err error) error {
+	if ce, ok := err.(cloud.MultiError); ok {
+		me := make(appengine.MultiError, len(ce))
+		for i, e := range ce {
+			me[i] = convertError(e)
+		}
+		return me
+	}
+	if err == cloud.ErrNoSuchEntity {
+		return datastore.ErrNoSuchEntity
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package cloudstore
+
+import (
+	"reflect"
+	"testing"
+
+	cloud "cloud.google.com/go/datastore"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+func TestKeyConversion(t *testing.T) {
+	c := aetools.OfflineContext(context.Background())
+	parent := datastore.NewKey(c, "Parent", "p1", 0, nil)
+	k := datastore.NewKey(c, "Child", "", 42, parent)
+
+	ck := toCloudKey(k)
+	if ck.Kind != "Child" || ck.ID != 42 || ck.Parent == nil || ck.Parent.Name != "p1" {
+		t.Errorf("Unexpected cloud key: %#v", ck)
+	}
+	if back := fromCloudKey(c, ck); !back.Equal(k) {
+		t.Errorf("Unexpected key after conversion: %v, expected %v", back, k)
+	}
+}
+
+func TestPropertyConversion(t *testing.T) {
+	c := aetools.OfflineContext(context.Background())
+	ref := datastore.NewKey(c, "Ref", "", 1, nil)
+	props := datastore.PropertyList{
+		{Name: "name", Value: "Test"},
+		{Name: "tags", Value: "a", Multiple: true, NoIndex: true},
+		{Name: "tags", Value: "b", Multiple: true, NoIndex: true},
+		{Name: "ref", Value: ref},
+		{Name: "blob", Value: appengine.BlobKey("blob-key")},
+	}
+
+	cp := toCloudProperties(props)
+	if len(cp) != 4 {
+		t.Fatalf("Unexpected cloud property count: %d, expected 4: %#v", len(cp), cp)
+	}
+	if v, ok := cp[1].Value.([]interface{}); !ok || !reflect.DeepEqual(v, []interface{}{"a", "b"}) {
+		t.Errorf("Unexpected repeated value: %#v", cp[1].Value)
+	}
+	if _, ok := cp[2].Value.(*cloud.Key); !ok {
+		t.Errorf("Unexpected key value: %#v", cp[2].Value)
+	}
+
+	back := fromCloudProperties(c, cp)
+	if len(back) != len(props) {
+		t.Fatalf("Unexpected property count: %d, expected %d", len(back), len(props))
+	}
+	for i, p := range props[:3] {
+		b := back[i]
+		if b.Name != p.Name || b.Multiple != p.Multiple || b.NoIndex != p.NoIndex {
+			t.Errorf("Unexpected property %d: %#v, expected %#v", i, b, p)
+		}
+	}
+	if k, ok := back[3].Value.(*datastore.Key); !ok || !k.Equal(ref) {
+		t.Errorf("Unexpected key value: %#v", back[3].Value)
+	}
+	if back[4].Value != "blob-key" {
+		t.Errorf("Unexpected blob key value: %#v, expected a string", back[4].Value)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package cloudstore implements an aetools.Store using the Cloud Datastore
+client library, cloud.google.com/go/datastore.
+
+This allows the aetools functions, like Dump and Load, to run outside
+App Engine, such as in Cloud Run services or command line tools, using
+the same fixture files:
+
+	client, err := datastore.NewClient(ctx, "my-project")
+	if err != nil {
+		// Handle error
+	}
+	c := cloudstore.NewContext(ctx, client)
+	_, err = aetools.Load(c, fixture, &aetools.Options{})
+
+Entity keys are converted from and to the App Engine *datastore.Key type,
+without the application ID. Repeated properties are converted from and to
+array values, and appengine.BlobKey values are saved as strings.
+*/
+package cloudstore // import "github.com/ronoaldo/aetools/cloudstore"
@@ -0,0 +1,140 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// EncodeFunc converts the value of p to the JSON compatible value saved in
+// the "value" attribute of a typed property. It returns ok false if the
+// codec does not handle the property.
+type EncodeFunc func(p datastore.Property) (value interface{}, ok bool, err error)
+
+// DecodeFunc converts the "value" attribute of a typed property, as decoded
+// from JSON with numbers as json.Number, to a property value.
+type DecodeFunc func(c context.Context, v interface{}) (interface{}, error)
+
+// codec is a registered custom property type.
+type codec struct {
+	name   string
+	encode EncodeFunc
+	decode DecodeFunc
+}
+
+// builtinTypes are the type names handled by the JSON format itself.
+var builtinTypes = map[string]bool{
+	"int": true, "float": true, "string": true, "bool": true,
+	"key": true, "blobkey": true, "blob": true, "date": true,
+}
+
+var (
+	codecMu sync.RWMutex
+	codecs  []*codec
+	byType  = make(map[string]*codec)
+)
+
+// RegisterCodec adds a custom property type to the JSON format, encoded as
+// {"type": typeName, "value": ...}. The decode function is called for
+// properties with the given type name, and the encode function is called
+// with each property before the built-in types are checked, so it can handle
+// Go types not supported by the JSON format, or represent some properties
+// with a more meaningful type, such as money saved as an integer in cents.
+// Encoders are called in the order they were registered. Either function
+// can be nil, for decode-only or encode-only types.
+//
+// RegisterCodec panics if typeName is empty, a built-in type name, or was
+// already registered. It is usually called from an init function.
+func RegisterCodec(typeName string, encode EncodeFunc, decode DecodeFunc) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	if typeName == "" || builtinTypes[typeName] {
+		panic(fmt.Sprintf("aetools: invalid codec type name %q", typeName))
+	}
+	if _, dup := byType[typeName]; dup {
+		panic(fmt.Sprintf("aetools: codec %q already registered", typeName))
+	}
+	c := &codec{name: typeName, encode: encode, decode: decode}
+	codecs = append(codecs, c)
+	byType[typeName] = c
+}
+
+// encodeCustom encodes p using the first registered codec that handles it.
+// It returns nil if no codec handles p.
+func encodeCustom(p datastore.Property) (map[string]interface{}, error) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	for _, c := range codecs {
+		if c.encode == nil {
+			continue
+		}
+		v, ok, err := c.encode(p)
+		if err != nil {
+			return nil, fmt.Errorf("aetools: can't encode %s as %s: %v", p.Name, c.name, err)
+		}
+		if ok {
+			return toMap(c.name, p.NoIndex, v), nil
+		}
+	}
+	return nil, nil
+}
+
+// decodeCustom decodes v using the codec registered for typeName.
+// It returns false if there is no such codec.
+func decodeCustom(c context.Context, name, typeName string, v interface{}) (interface{}, bool, error) {
+	codecMu.RLock()
+	cd := byType[typeName]
+	codecMu.RUnlock()
+	if cd == nil || cd.decode == nil {
+		return nil, false, nil
+	}
+	value, err := cd.decode(c, v)
+	if err != nil {
+		return nil, true, fmt.Errorf("aetools: can't decode %s as %s: %v", name, typeName, err)
+	}
+	return value, true, nil
+}
+
+func init() {
+	// GeoPoint values are encoded as {"lat": 1.5, "lng": -2.5}
+	RegisterCodec("geopoint", func(p datastore.Property) (interface{}, bool, error) {
+		g, ok := p.Value.(appengine.GeoPoint)
+		if !ok {
+			return nil, false, nil
+		}
+		if !g.Valid() {
+			return nil, false, fmt.Errorf("invalid GeoPoint %v", g)
+		}
+		return map[string]interface{}{"lat": float(g.Lat), "lng": float(g.Lng)}, true, nil
+	}, func(c context.Context, v interface{}) (interface{}, error) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value is not an object: %v", v)
+		}
+		var g appengine.GeoPoint
+		for _, f := range []struct {
+			name  string
+			value *float64
+		}{{"lat", &g.Lat}, {"lng", &g.Lng}} {
+			n, ok := m[f.name].(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("missing or invalid %s: %v", f.name, m[f.name])
+			}
+			var err error
+			if *f.value, err = n.Float64(); err != nil {
+				return nil, err
+			}
+		}
+		if !g.Valid() {
+			return nil, fmt.Errorf("invalid GeoPoint %v", g)
+		}
+		return g, nil
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// registerMoneyCodec registers the "money" codec used in the tests, and
+// returns a function that removes it, so other tests are not affected.
+// money properties are saved as int64 cents, and encoded as a decimal
+// string, like "12.34".
+func registerMoneyCodec() func() {
+	RegisterCodec("money", func(p datastore.Property) (interface{}, bool, error) {
+		cents, ok := p.Value.(int64)
+		if !ok || !strings.HasSuffix(p.Name, "Cents") {
+			return nil, false, nil
+		}
+		sign := ""
+		if cents < 0 {
+			sign, cents = "-", -cents
+		}
+		return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100), true, nil
+	}, func(c context.Context, v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("value is not a string: %v", v)
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		if f < 0 {
+			return int64(f*100 - 0.5), nil
+		}
+		return int64(f*100 + 0.5), nil
+	})
+	return func() {
+		codecMu.Lock()
+		defer codecMu.Unlock()
+		delete(byType, "money")
+		for i, c := range codecs {
+			if c.name == "money" {
+				codecs = append(codecs[:i], codecs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	defer registerMoneyCodec()()
+	fixture := `[{"__key__": ["Product", 1],
+		"priceCents": {"type": "money", "value": "12.34"},
+		"stock": 10,
+		"location": {"type": "geopoint", "indexed": false, "value": {"lat": -19.9, "lng": -43.9}}
+	}]`
+	entities, err := DecodeEntities(offline, strings.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := entities[0]
+	if cents := e.Get("priceCents"); cents != int64(1234) {
+		t.Errorf("Unexpected priceCents: %#v, expected 1234", cents)
+	}
+	if g, ok := e.Get("location").(appengine.GeoPoint); !ok || g.Lat != -19.9 || g.Lng != -43.9 {
+		t.Errorf("Unexpected location: %#v", e.Get("location"))
+	}
+
+	var b bytes.Buffer
+	if err := EncodeCanonical(&b, entities); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[
+{
+  "__key__": ["Product",1],
+  "location": {"indexed":false,"type":"geopoint","value":{"lat":-19.9,"lng":-43.9}},
+  "priceCents": {"indexed":true,"type":"money","value":"12.34"},
+  "stock": 10
+}
+]
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected encoded entity:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+
+	for _, m := range []struct {
+		Cents int64
+		Value string
+	}{{5, "0.05"}, {-5, "-0.05"}, {-1234, "-12.34"}, {100, "1.00"}} {
+		e := &Entity{Key: datastore.NewKey(offline, "Product", "", 1, nil)}
+		e.Add(datastore.Property{Name: "priceCents", Value: m.Cents})
+		b, err := e.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf(`"priceCents":{"indexed":true,"type":"money","value":%q}`, m.Value); !strings.Contains(string(b), want) {
+			t.Errorf("Unexpected encoding of %d cents: %s, expected %s", m.Cents, b, want)
+		}
+		l, err := DecodeEntities(offline, strings.NewReader("["+string(b)+"]"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cents := l[0].Get("priceCents"); cents != m.Cents {
+			t.Errorf("Unexpected decoded cents for %s: %#v, expected %d", m.Value, cents, m.Cents)
+		}
+	}
+
+	invalid := []string{
+		`[{"__key__": ["Product", 1], "priceCents": {"type": "money", "value": 12}}]`,
+		`[{"__key__": ["Product", 1], "location": {"type": "geopoint", "value": {"lat": 91, "lng": 0}}}]`,
+		`[{"__key__": ["Product", 1], "location": {"type": "geopoint", "value": {"lat": 1}}}]`,
+	}
+	for _, s := range invalid {
+		if _, err := DecodeEntities(offline, strings.NewReader(s)); err == nil {
+			t.Errorf("Expected error decoding %s", s)
+		}
+	}
+
+	for _, name := range []string{"", "int", "date", "money"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic registering codec %q", name)
+				}
+			}()
+			RegisterCodec(name, nil, nil)
+		}()
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/remote_api"
+)
+
+// ErrOffline is returned by App Engine API calls made using a context
+// created with OfflineContext.
+var ErrOffline = errors.New("aetools: API call using an offline context")
+
+// OfflineContext returns a copy of parent that can be used to encode and
+// decode entities without a connection to the App Engine APIs, for instance
+// when parsing fixture files. Keys built with the returned context have an
+// empty application ID, and log calls are sent to the standard log package.
+// Any App Engine API call performed with it returns ErrOffline.
+func OfflineContext(parent context.Context) context.Context {
+	// A zero remote_api.Client gives us the app ID and log overrides,
+	// and we replace the API calls it would perform with ErrOffline.
+	c := new(remote_api.Client).NewContext(parent)
+	return appengine.WithAPICallFunc(c, func(context.Context, string, string, proto.Message, proto.Message) error {
+		return ErrOffline
+	})
+}
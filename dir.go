@@ -0,0 +1,184 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// DumpDir exports entities like Dump, writing each one to its own file
+// at <dir>/<Kind>/<KeyFileName>.json, where Kind is the entity kind.
+// Each file is a JSON array with a single entity, so it can also be
+// loaded with Load. Use Options.Canonical to get a stable output,
+// suitable for SCM checkin.
+//
+// After the dump, files of the dumped kinds without a matching entity
+// are removed, so deleted entities are also deleted from the tree.
+// Files are not removed when resuming a dump from Options.Start.
+//
+// Keys with file names that differ only in case are reported as an error,
+// as they would be saved to the same file in case-insensitive file systems.
+func DumpDir(c context.Context, dir string, o *Options) error {
+	// Written files by their lowercase name
+	written := make(map[string]*datastore.Key)
+	dirs := make(map[string]bool)
+	if o.Kind != "" {
+		// Also cleanup the kind directory if all entities were removed
+		dirs[filepath.Join(dir, escapePathElement(o.Kind))] = false
+	}
+	var p *progress
+	if o.Progress != nil {
+		p = newProgress(o, kindCount(c, o.Kind))
+	}
+	err := dumpBatches(c, o, o.Start, p, func(e *Entity) error {
+		kindDir := filepath.Join(dir, escapePathElement(e.Key.Kind()))
+		if created := dirs[kindDir]; !created {
+			if err := os.MkdirAll(kindDir, 0755); err != nil {
+				return err
+			}
+			dirs[kindDir] = true
+		}
+		b, err := o.marshal(e)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if o.Canonical {
+			buf.Write(canonicalOpen)
+			buf.Write(b)
+			buf.Write(canonicalClose)
+		} else {
+			fmt.Fprintf(&buf, "[%s]\n", b)
+		}
+		name := filepath.Join(kindDir, KeyFileName(e.Key))
+		if k := written[strings.ToLower(name)]; k != nil {
+			return fmt.Errorf("aetools: file name %s for key %v clashes with the file of key %v", name, e.Key, k)
+		}
+		written[strings.ToLower(name)] = e.Key
+		p.addBytes(int64(buf.Len()))
+		return ioutil.WriteFile(name, buf.Bytes(), 0644)
+	})
+	if err != nil || o.Start != "" {
+		return err
+	}
+	for kindDir := range dirs {
+		files, err := filepath.Glob(filepath.Join(kindDir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if written[strings.ToLower(f)] != nil {
+				continue
+			}
+			log.Infof(c, "dump: removing stale file %s", f)
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadDir walks the directory tree dir, reading the entities from all
+// files with the .json extension, and saves them like Load. Files are
+// read in lexical order, and may contain a JSON array with any number
+// of entities, so the output of both DumpDir and Dump can be loaded.
+// The failures in the result are indexed by the entity position in the
+// concatenation of all files.
+func LoadDir(c context.Context, dir string, o *Options) (*LoadResult, error) {
+	result := new(LoadResult)
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+	var entities []Entity
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		l, err := DecodeEntities(c, fd)
+		if err != nil {
+			return fmt.Errorf("aetools: error decoding %s: %v", path, err)
+		}
+		entities = append(entities, l...)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Read = len(entities)
+	index := make([]int, len(entities))
+	for i := range index {
+		index[i] = i
+	}
+	return result, loadEntities(c, entities, index, o, result)
+}
+
+// KeyFileName returns the file name used by DumpDir to save the entity
+// with key k. The name is the KeyPath of k, with the .json extension.
+// Characters other than ASCII letters, digits, '-', '_' and '.' are
+// escaped in each path element as %XX, as well as the first digit of
+// string IDs made of digits only, so they don't clash with integer IDs.
+func KeyFileName(k *datastore.Key) string {
+	var path []string
+	for p := k; p != nil; p = p.Parent() {
+		id := strconv.FormatInt(p.IntID(), 10)
+		if p.IntID() == 0 {
+			id = escapePathElement(p.StringID())
+			if isDigits(p.StringID()) {
+				id = fmt.Sprintf("%%%02X", p.StringID()[0]) + id[1:]
+			}
+		}
+		path = append([]string{escapePathElement(p.Kind()), id}, path...)
+	}
+	return strings.Join(path, ",") + ".json"
+}
+
+// escapePathElement escapes s so it can be safely used as part of a
+// file name in any operating system. The case of letters is kept, so
+// the escaped elements may clash in case-insensitive file systems.
+func escapePathElement(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.' && i > 0:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// isDigits reports if s is a non empty string with only decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestKeyFileName(t *testing.T) {
+	parent := datastore.NewKey(offline, "Parent", "a/b", 0, nil)
+	cases := []struct {
+		Key      *datastore.Key
+		Expected string
+	}{
+		{datastore.NewKey(offline, "User", "", 123, nil), "User,123.json"},
+		{datastore.NewKey(offline, "User", "123", 0, nil), "User,%3123.json"},
+		{datastore.NewKey(offline, "User", "ana@example.com", 0, nil), "User,ana%40example.com.json"},
+		{datastore.NewKey(offline, "Child", "", 1, parent), "Parent,a%2Fb,Child,1.json"},
+		{datastore.NewKey(offline, "User", "a,b", 0, nil), "User,a%2Cb.json"},
+		{datastore.NewKey(offline, "User", "..", 0, nil), "User,%2E..json"},
+	}
+	for _, c := range cases {
+		if name := KeyFileName(c.Key); name != c.Expected {
+			t.Errorf("Unexpected file name for %v: %s, expected %s", c.Key, name, c.Expected)
+		}
+	}
+}
+
+func TestDumpAndLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aetools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, memoryFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(dir, "Order", "Order,99.json")
+	os.MkdirAll(filepath.Dir(stale), 0755)
+	if err := ioutil.WriteFile(stale, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DumpDir(c, dir, &Options{Kind: "Order", Canonical: true}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "Order", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 6 {
+		t.Errorf("Unexpected number of files: %d, expected 6: %v", len(files), files)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale file was not removed: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "Order", "Customer,ana,Order,5.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[\n{\n" +
+		`  "__key__": ["Customer","ana","Order",5],` + "\n" +
+		`  "customer": "ana",` + "\n" +
+		`  "total": 15` + "\n}\n]\n"
+	if string(b) != expected {
+		t.Errorf("Unexpected file content:\n%s\nexpected:\n%s", b, expected)
+	}
+
+	other := NewMemoryContext(context.Background())
+	if _, err := LoadDir(other, dir, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	if n := StoreFromContext(other).(*MemoryStore).Len(); n != 6 {
+		t.Errorf("Unexpected loaded entities: %d, expected 6", n)
+	}
+	k := datastore.NewKey(c, "Order", "x", 0, nil)
+	a, err := Get(c, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Get(other, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sameEntity(a, l); err != nil {
+		t.Errorf("Loaded entity mismatch: %v", err)
+	}
+}
+
+func TestDumpDirCaseClash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aetools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewMemoryContext(context.Background())
+	fixture := `[{"__key__": ["User", "ABC"], "n": 1}, {"__key__": ["User", "abc"], "n": 2}]`
+	if err := LoadJSON(c, fixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	err = DumpDir(c, dir, &Options{Kind: "User"})
+	if err == nil || !strings.Contains(err.Error(), "clashes") {
+		t.Errorf("Unexpected error for keys differing only in case: %v", err)
+	}
+}
@@ -35,8 +35,14 @@ When the primitives are not sufficient to represent the property value,
 a JSON Object with the attributes "type" and "value" is used. The
 "type" attribute is a Datastore type, and value is a json-primitive
 serialization of that value. For instance, Blobs are encoded as a
-base64 JSON string, and time.Time values are encoded using the
-time.RFC3339 layout, also as strings.
+base64 JSON string, and time.Time values are encoded in UTC using the
+time.RFC3339Nano layout, also as strings, so no precision is lost.
+
+Numbers with a decimal point or an exponent, like 1.0 or 1e5, are decoded
+as float64 values, and other numbers as int64 values. The "type" attribute
+can be set to "int" or "float" to choose the type explicitly. Numbers that
+can't be represented by the decoded type, like integers out of the int64
+range, are reported as errors.
 
 Datastore Keys are aways encoded as a JSON Array that represents
 the Key Path, including ancestors, but without the application ID.
@@ -47,14 +53,84 @@ Multiple properties are represented as a JSON Array of values described
 above. Unindexed properties are aways JSON objects with the "indexed"
 attribute set to false.
 
+GeoPoint values use the "geopoint" type, with a JSON Object with the "lat"
+and "lng" attributes as value. Other custom types can be added with
+RegisterCodec, to support more Go types or to give some properties a more
+meaningful representation in fixtures, like money saved as an integer
+number of cents:
+
+	{"price": {"type": "money", "value": "12.34"}}
+
 This format is intended to make use of the JSON types as much as possible,
 so an entity can be easily represented as a text file, suitable for read or
 SCM checkin.
 
+When the Options.Canonical flag is set, Dump uses a canonical layout of
+this format: the "__key__" attribute is aways the first one, followed by
+the properties sorted by name, one per line, with each value encoded as a
+single line of compact JSON. Repeated dumps of unchanged data produce the
+same bytes, making the output suitable for review in pull requests.
+
+DumpDir and LoadDir use a directory tree instead of a single stream,
+with one file per entity at <dir>/<Kind>/<KeyFileName>.json. This keeps
+changes to different entities in different files, avoiding merge conflicts
+when the fixtures are kept in version control.
+
+ReadEntities and WriteEntities convert the entities from and to other
+formats with the same structure: newline delimited JSON, YAML and CSV.
+The command aetools/aeconvert exposes them to convert files offline.
+
 The exported data format can also be used as an alternative way to
 export from Datastore, and then load the results right into other
 service, such as Google BigQuery or MongoDB.
 
+Datastore Backends
+
+The functions in this package access the datastore using a Store, that
+defaults to the google.golang.org/appengine/datastore API. Use WithStore
+to set a different backend in the context, such as the Cloud Datastore
+client implementation from the package aetools/cloudstore. This allows
+the same fixtures and tools to be used by services outside App Engine.
+
+For tests, NewMemoryContext returns a context backed by a MemoryStore,
+a pure Go in-memory implementation that supports key-ordered queries,
+filters, ancestors, cursors, the __scatter__ property and the datastore
+statistics kinds, without the App Engine SDK:
+
+	c := aetools.NewMemoryContext(context.Background())
+	err := aetools.LoadJSON(c, fixture, aetools.LoadSync)
+
+The tests in this repository use the in-memory store by default; run
+them with the -appengine flag to use the App Engine SDK instead.
+
+ParseGQL parses a query written in a subset of GQL into a Query, that can
+be executed with the Store from the context:
+
+	q, _, err := aetools.ParseGQL(c, "SELECT * FROM Order WHERE status = 'open' ORDER BY created LIMIT 100")
+	it := aetools.StoreFromContext(c).Run(c, q)
+
+Processing Kinds in Parallel
+
+Map runs a function over all entities of a kind, in batches, for jobs like
+backfills, recounts and cleanups. The kind is split in key ranges using the
+__scatter__ property, and the ranges are processed concurrently by a bounded
+number of workers. When MapOptions.Job is set, the progress of each range is
+saved after every batch, so calling Map again with the same job name resumes
+an interrupted run:
+
+	n, err := aetools.Map(c, "Order", aetools.EachEntity(recount),
+		&aetools.MapOptions{BatchSize: 100, Workers: 8, Job: "recount-2016"})
+
+Search API Indexes
+
+DumpIndex and LoadIndex export and load the documents of a Search API
+index, like Dump and Load do with entities, so indexes can also have
+fixtures. Documents are encoded as JSON objects with the "__id__" attribute
+and one attribute per field; see Document for details:
+
+	{"__id__": "p1", "Name": "Blue shirt", "Price": 19.9,
+	 "Color": {"type": "atom", "value": "blue"}}
+
 The Web Bundle
 
 The package aetools/bundle contains a sample webapp to help you
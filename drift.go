@@ -0,0 +1,139 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// TypeDrift describes a property saved with more than one type.
+type TypeDrift struct {
+	// Property is the property name, prefixed by the kind, as Kind.property.
+	Property string
+	// Types are the property types, in descending count order.
+	Types []*PropertyType
+}
+
+// PropertyType is the number of values of a property with a given type.
+type PropertyType struct {
+	// Type is the type name, as used in the datastore statistics.
+	// Indexed and unindexed strings and blobs are reported as
+	// "String" and "Blob", as they have the same Go type.
+	Type string
+	// Count is the number of values with this type.
+	Count int64
+	// Samples are keys of entities with values of this type.
+	// Not available when reading the datastore statistics.
+	Samples []*datastore.Key
+}
+
+// TypeDriftFromStats returns the properties of kind saved with more than
+// one type, using the __Stat_PropertyType_PropertyName_Kind__ statistics.
+func TypeDriftFromStats(c context.Context, kind string) ([]*TypeDrift, error) {
+	types := make(map[string]map[string]*PropertyType)
+	q := &Query{
+		Kind:    statPropertyTypeKind,
+		Filters: []Filter{{Property: "kind_name", Operator: "=", Value: kind}},
+	}
+	for it := StoreFromContext(c).Run(c, q); ; {
+		var e Entity
+		_, err := it.Next(&e)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		pt := propertyType(types, kind+"."+e.GetString("property_name"), e.GetString("property_type"))
+		pt.Count += e.GetInt("count")
+	}
+	return typeDrifts(types), nil
+}
+
+// TypeDriftFromScan returns the properties of kind saved with more than
+// one type, scanning all entities of the kind. Up to samples keys are
+// kept for each property type.
+func TypeDriftFromScan(c context.Context, kind string, samples int, o *Options) ([]*TypeDrift, error) {
+	types := make(map[string]map[string]*PropertyType)
+	ko := *o
+	ko.Kind = kind
+	err := dumpEntities(c, &ko, func(e *Entity) error {
+		for _, p := range e.Properties {
+			pt := propertyType(types, kind+"."+p.Name, propertyTypeName(p))
+			pt.Count++
+			n := len(pt.Samples)
+			if n < samples && (n == 0 || !pt.Samples[n-1].Equal(e.Key)) {
+				pt.Samples = append(pt.Samples, e.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return typeDrifts(types), nil
+}
+
+// propertyType returns the PropertyType in types for the given property
+// and type name, creating it if needed.
+func propertyType(types map[string]map[string]*PropertyType, property, typ string) *PropertyType {
+	switch typ {
+	case "Text":
+		typ = "String"
+	case "ShortBlob":
+		typ = "Blob"
+	}
+	m := types[property]
+	if m == nil {
+		m = make(map[string]*PropertyType)
+		types[property] = m
+	}
+	pt := m[typ]
+	if pt == nil {
+		pt = &PropertyType{Type: typ}
+		m[typ] = pt
+	}
+	return pt
+}
+
+// typeDrifts returns the properties in types with more than one type,
+// sorted by property name.
+func typeDrifts(types map[string]map[string]*PropertyType) []*TypeDrift {
+	var r []*TypeDrift
+	for property, m := range types {
+		if len(m) < 2 {
+			continue
+		}
+		d := &TypeDrift{Property: property}
+		for _, pt := range m {
+			d.Types = append(d.Types, pt)
+		}
+		sort.Sort(byTypeCount(d.Types))
+		r = append(r, d)
+	}
+	sort.Sort(byDriftProperty(r))
+	return r
+}
+
+// byTypeCount sorts types by count in descending order.
+type byTypeCount []*PropertyType
+
+func (b byTypeCount) Len() int      { return len(b) }
+func (b byTypeCount) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byTypeCount) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Type < b[j].Type
+}
+
+// byDriftProperty sorts drifts by property name.
+type byDriftProperty []*TypeDrift
+
+func (b byDriftProperty) Len() int           { return len(b) }
+func (b byDriftProperty) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDriftProperty) Less(i, j int) bool { return b[i].Property < b[j].Property }
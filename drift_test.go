@@ -0,0 +1,76 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+var driftFixture = `[
+	{"__key__": ["Account", 1], "age": 30, "name": "Ana", "tags": ["a", "b"]},
+	{"__key__": ["Account", 2], "age": 31, "name": {"type": "string", "indexed": false, "value": "Bob"}},
+	{"__key__": ["Account", 3], "age": "32", "name": "Carl", "tags": ["c", 1]},
+	{"__key__": ["Account", 4], "age": null, "name": "Dan"}
+]`
+
+// formatDrifts formats drifts as Kind.property[Type:Count:Samples,...] ...
+func formatDrifts(drifts []*TypeDrift, samples bool) string {
+	var r []string
+	for _, d := range drifts {
+		var types []string
+		for _, pt := range d.Types {
+			s := fmt.Sprintf("%s:%d", pt.Type, pt.Count)
+			if samples {
+				var keys []string
+				for _, k := range pt.Samples {
+					keys = append(keys, KeyPath(k))
+				}
+				s += ":" + strings.Join(keys, "|")
+			}
+			types = append(types, s)
+		}
+		r = append(r, d.Property+"["+strings.Join(types, ",")+"]")
+	}
+	return strings.Join(r, " ")
+}
+
+func TestTypeDrift(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, driftFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err := TypeDriftFromScan(c, "Account", 5, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Account.age[Integer:2:Account,1|Account,2,NULL:1:Account,4,String:1:Account,3] " +
+		"Account.tags[String:3:Account,1|Account,3,Integer:1:Account,3]"
+	if s := formatDrifts(drifts, true); s != expected {
+		t.Errorf("Unexpected drifts from scan:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	drifts, err = TypeDriftFromScan(c, "Account", 1, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "Account.age[Integer:2:Account,1,NULL:1:Account,4,String:1:Account,3] " +
+		"Account.tags[String:3:Account,1,Integer:1:Account,3]"
+	if s := formatDrifts(drifts, true); s != expected {
+		t.Errorf("Unexpected drifts from scan with one sample:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	drifts, err = TypeDriftFromStats(c, "Account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "Account.age[Integer:2,NULL:1,String:1] Account.tags[String:3,Integer:1]"
+	if s := formatDrifts(drifts, false); s != expected {
+		t.Errorf("Unexpected drifts from stats:\n%s\nexpected:\n%s", s, expected)
+	}
+}
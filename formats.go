@@ -0,0 +1,296 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Formats supported by ReadEntities and WriteEntities.
+const (
+	// FormatJSON is the JSON array written by Dump and read by Load.
+	FormatJSON = "json"
+
+	// FormatNDJSON is the newline delimited JSON format, with one
+	// entity object per line.
+	FormatNDJSON = "ndjson"
+
+	// FormatYAML is a YAML sequence of entities, with the same
+	// structure of the JSON format.
+	FormatYAML = "yaml"
+
+	// FormatCSV is a table with one entity per row, the "__key__"
+	// column and one column per property. See WriteEntities for details.
+	FormatCSV = "csv"
+)
+
+// FormatFromName returns the format for the file name extension, or an
+// empty string if the extension is unknown.
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".csv":
+		return FormatCSV
+	}
+	return ""
+}
+
+// ReadEntities decodes the entities read from r using the given format.
+// It needs no datastore access when c is an OfflineContext.
+func ReadEntities(c context.Context, r io.Reader, format string) ([]Entity, error) {
+	switch format {
+	case FormatJSON:
+		return DecodeEntities(c, r)
+	case FormatNDJSON:
+		return readNDJSON(c, r)
+	case FormatYAML:
+		v, err := parseYAML(r)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, nil
+		}
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, ErrInvalidRootElement
+		}
+		return decodeEntities(c, a)
+	case FormatCSV:
+		return readCSV(c, r)
+	}
+	return nil, fmt.Errorf("aetools: unknown format %q", format)
+}
+
+// WriteEntities encodes the entities to w using the given format.
+// The JSON format honors o.PrettyPrint and o.Canonical, and the YAML and
+// CSV formats aways use a stable layout, with the properties sorted by name.
+//
+// In the CSV format, the first row has the column names: "__key__" followed
+// by all property names. Each cell has the property value as encoded in the
+// JSON format, and strings that are not valid JSON values are written
+// without quotes. Empty cells are used for missing properties.
+//
+// A nil o is the same as the zero Options.
+func WriteEntities(w io.Writer, entities []Entity, format string, o *Options) error {
+	if o == nil {
+		o = &Options{}
+	}
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, entities, o)
+	case FormatNDJSON:
+		for i := range entities {
+			b, err := json.Marshal(&entities[i])
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatYAML:
+		values, err := entityValues(entities)
+		if err != nil {
+			return err
+		}
+		a := make([]interface{}, len(values))
+		for i := range values {
+			a[i] = values[i]
+		}
+		var b bytes.Buffer
+		if err := writeYAML(&b, a, 0); err != nil {
+			return err
+		}
+		_, err = w.Write(b.Bytes())
+		return err
+	case FormatCSV:
+		return writeCSV(w, entities)
+	}
+	return fmt.Errorf("aetools: unknown format %q", format)
+}
+
+// writeJSON writes entities as a JSON array, using the same layout of Dump.
+func writeJSON(w io.Writer, entities []Entity, o *Options) error {
+	switch {
+	case o.Canonical:
+		return EncodeCanonical(w, entities)
+	case o.PrettyPrint:
+		return encodeArray(w, entities, o)
+	}
+	return EncodeEntities(entities, w)
+}
+
+// readNDJSON decodes one entity per line read from r.
+func readNDJSON(c context.Context, r io.Reader) ([]Entity, error) {
+	var a []interface{}
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			v, perr := parseJSONValue(text)
+			if perr != nil {
+				return nil, fmt.Errorf("aetools: ndjson line %d: %v", line, perr)
+			}
+			a = append(a, v)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return decodeEntities(c, a)
+}
+
+// readCSV decodes one entity per row read from r.
+func readCSV(c context.Context, r io.Reader) ([]Entity, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	if len(header) == 0 || header[0] != "__key__" {
+		return nil, fmt.Errorf("aetools: csv first column must be __key__")
+	}
+	a := make([]interface{}, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		m := make(map[string]interface{})
+		for i, cell := range row {
+			if cell == "" {
+				continue
+			}
+			v, err := parseJSONValue(cell)
+			if err != nil {
+				// Not a JSON value, so it is an unquoted string
+				v = cell
+			}
+			m[header[i]] = v
+		}
+		a = append(a, m)
+	}
+	return decodeEntities(c, a)
+}
+
+// writeCSV writes entities as a table, with one column per property.
+func writeCSV(w io.Writer, entities []Entity) error {
+	values, err := entityValues(entities)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, m := range values {
+		for n := range m {
+			if n != "__key__" {
+				names[n] = true
+			}
+		}
+	}
+	header := make([]string, 0, len(names)+1)
+	for n := range names {
+		header = append(header, n)
+	}
+	sort.Strings(header)
+	header = append([]string{"__key__"}, header...)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, m := range values {
+		row := make([]string, len(header))
+		for i, n := range header {
+			v, ok := m[n]
+			if !ok {
+				continue
+			}
+			if s, ok := v.(string); ok {
+				// Carriage returns are quoted, as they are not preserved by csv
+				if _, err := parseJSONValue(s); err != nil && s != "" && !strings.ContainsRune(s, '\r') {
+					row[i] = s
+					continue
+				}
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			row[i] = string(b)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// entityValues returns the entities as generic JSON objects.
+func entityValues(entities []Entity) ([]map[string]interface{}, error) {
+	values := make([]map[string]interface{}, len(entities))
+	for i := range entities {
+		b, err := json.Marshal(&entities[i])
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseJSONValue(string(b))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v.(map[string]interface{})
+	}
+	return values, nil
+}
+
+// parseJSONValue parses s as a single JSON value, using json.Number
+// for numbers.
+func parseJSONValue(s string) (interface{}, error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// decodeEntities decodes the entity objects in a.
+func decodeEntities(c context.Context, a []interface{}) ([]Entity, error) {
+	var result []Entity
+	for _, i := range a {
+		m, ok := i.(map[string]interface{})
+		if !ok {
+			return nil, ErrInvalidElementType
+		}
+		e, err := decodeEntity(c, m)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *e)
+	}
+	return result, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestFormatsRoundTrip(t *testing.T) {
+	formats := []string{FormatJSON, FormatNDJSON, FormatYAML, FormatCSV}
+	for _, format := range formats {
+		f := func(a, b randomEntity) bool {
+			var buf bytes.Buffer
+			entities := []Entity{a.Entity, b.Entity}
+			if err := WriteEntities(&buf, entities, format, &Options{}); err != nil {
+				t.Logf("%s: encode error: %v", format, err)
+				return false
+			}
+			decoded, err := ReadEntities(offline, bytes.NewReader(buf.Bytes()), format)
+			if err != nil {
+				t.Logf("%s: decode error: %v\n%s", format, err, buf.String())
+				return false
+			}
+			if len(decoded) != len(entities) {
+				t.Logf("%s: decoded %d entities, expected %d", format, len(decoded), len(entities))
+				return false
+			}
+			for i := range entities {
+				if err := sameEntity(&entities[i], &decoded[i]); err != nil {
+					t.Logf("%s: round-trip mismatch: %v\n%s", format, err, buf.String())
+					return false
+				}
+			}
+			return true
+		}
+		cfg := &quick.Config{MaxCount: 300, Rand: rand.New(rand.NewSource(1))}
+		if err := quick.Check(f, cfg); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+}
+
+func TestWriteEntities(t *testing.T) {
+	entities, err := ReadEntities(offline, strings.NewReader(`[
+		{"__key__": ["User", 1], "name": "Ana", "age": 31, "tags": ["a", "b"]},
+		{"__key__": ["User", "bob"], "name": "true", "note": {"type": "string", "indexed": false, "value": "x: y"}}
+	]`), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		FormatJSON: `[{"__key__":["User",1],"age":31,"name":"Ana","tags":["a","b"]},
+{"__key__":["User","bob"],"name":"true","note":{"indexed":false,"type":"string","value":"x: y"}}]`,
+		FormatNDJSON: `{"__key__":["User",1],"age":31,"name":"Ana","tags":["a","b"]}
+{"__key__":["User","bob"],"name":"true","note":{"indexed":false,"type":"string","value":"x: y"}}
+`,
+		FormatYAML: `- __key__: ["User",1]
+  age: 31
+  name: Ana
+  tags: ["a","b"]
+- __key__: ["User","bob"]
+  name: "true"
+  note:
+    indexed: false
+    type: string
+    value: "x: y"
+`,
+		FormatCSV: `__key__,age,name,note,tags
+"[""User"",1]",31,Ana,,"[""a"",""b""]"
+"[""User"",""bob""]",,"""true""","{""indexed"":false,""type"":""string"",""value"":""x: y""}",
+`,
+	}
+	for format, want := range expected {
+		var b bytes.Buffer
+		if err := WriteEntities(&b, entities, format, nil); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != want {
+			t.Errorf("%s: unexpected output:\n%s\nexpected:\n%s", format, b.String(), want)
+		}
+	}
+}
+
+func TestReadYAML(t *testing.T) {
+	doc := `---
+# Users fixture
+- __key__:
+  - User
+  - 1
+  name: 'Ana''s'
+  tags:
+  - a
+  - "b"
+  score: 1.5
+  active: true
+- __key__: [User, 2]
+  name: Bob
+  aliases: ['B, the builder', "Bobby"]
+`
+	entities, err := ReadEntities(offline, strings.NewReader(doc), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 {
+		t.Fatalf("Unexpected entities: %d, expected 2", len(entities))
+	}
+	e := entities[0]
+	if e.Key.IntID() != 1 || e.GetString("name") != "Ana's" || e.GetBool("active") != true {
+		t.Errorf("Unexpected entity: %#v", e)
+	}
+	if f, ok := e.Get("score").(float64); !ok || f != 1.5 {
+		t.Errorf("Unexpected score: %#v", e.Get("score"))
+	}
+	var tags []interface{}
+	for _, p := range e.Properties {
+		if p.Name == "tags" && p.Multiple {
+			tags = append(tags, p.Value)
+		}
+	}
+	if len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("Unexpected tags: %#v", tags)
+	}
+	if e := entities[1]; e.Key.IntID() != 2 || e.GetString("aliases") != "B, the builder" {
+		t.Errorf("Unexpected entity: %#v", e)
+	}
+}
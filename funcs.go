@@ -10,17 +10,25 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 )
 
 const (
-	// DateTimeFormat is used to store and load time.Time objects
-	DateTimeFormat = time.RFC3339
+	// DateTimeFormat is used to store and load time.Time objects.
+	// It keeps the nanoseconds, so no precision is lost when dumping.
+	DateTimeFormat = time.RFC3339Nano
+
+	// FormatVersion is the version of the JSON format used by Dump and Load.
+	// It is recorded in backups to detect incompatible archives.
+	FormatVersion = "1"
 )
 
 var (
@@ -57,13 +65,70 @@ type Options struct {
 	// The size for batch operations when loading/dumping
 	BatchSize int
 
+	// Workers is the number of batches saved concurrently by Load.
+	// Not used when dumping.
+	Workers int
+
+	// SerializeGroups makes Load never save entities of the same entity
+	// group in concurrent batches, to avoid contention errors when
+	// Workers is greater than one. Not used when dumping.
+	SerializeGroups bool
+
+	// MaxQPS limits the datastore calls per second made by Dump and Load,
+	// including the calls of all workers. Zero means no limit.
+	MaxQPS float64
+
+	// MaxEntitiesPerSecond limits the entities read by Dump or saved by
+	// Load per second. Zero means no limit.
+	MaxEntitiesPerSecond float64
+
+	// Retries is the number of times a batch is retried after a datastore
+	// timeout or contention error, doubling the wait before each retry.
+	// Load doesn't retry batches with incomplete keys, as their entities
+	// could be saved twice.
+	Retries int
+
+	// Progress, if set, is called by Dump, DumpDir, Load and LoadDir after
+	// each batch. It is never called concurrently.
+	Progress func(Stats)
+
+	// ContinueOnError makes Load save all valid entities, reporting the
+	// failed ones in the LoadResult, instead of stopping at the first error.
+	// Not used when dumping.
+	ContinueOnError bool
+
+	// Skip is the number of entities at the start of the input ignored
+	// by Load, to resume an interrupted load. Not used when dumping.
+	Skip int
+
 	// Kind is used to specify the kind when dumping.
 	// Not used when loading.
 	Kind string
 
+	// Start is a query cursor where Dump or DumpDir starts, to resume an
+	// interrupted dump. Not used when loading.
+	Start string
+
 	// PrettyPrint is used to specify if the dump should beaultify the output.
 	// Not used when loading.
 	PrettyPrint bool
+
+	// Canonical is used to specify if the dump should use the canonical
+	// output, suitable for SCM checkin. Takes precedence over PrettyPrint.
+	// Not used when loading.
+	Canonical bool
+}
+
+// marshal encodes e as JSON, using the output format specified in o.
+func (o *Options) marshal(e *Entity) ([]byte, error) {
+	switch {
+	case o.Canonical:
+		return e.MarshalCanonical()
+	case o.PrettyPrint:
+		return json.MarshalIndent(e, "", "  ")
+	default:
+		return json.Marshal(e)
+	}
 }
 
 // DumpOptions is deprecated. Use Options instead.
@@ -74,61 +139,399 @@ type DumpOptions struct {
 // LoadJSON is a convenient wrapper to call Load using a JSON string in memory,
 // wrapped by a strings.Reader. The error result from Load, if any, is returned.
 func LoadJSON(c context.Context, s string, o *Options) error {
-	return Load(c, strings.NewReader(s), o)
+	_, err := Load(c, strings.NewReader(s), o)
+	return err
+}
+
+// LoadResult reports the outcome of Load. Each entity read is either
+// written, failed or skipped, so Read is aways the sum of Written, Skipped
+// and the number of Failed entities.
+type LoadResult struct {
+	// Read is the number of entities read from the input.
+	Read int
+	// Written is the number of entities saved in the datastore.
+	Written int
+	// Skipped is the number of entities not saved because Load stopped
+	// at an error, was interrupted, or was asked to skip them.
+	Skipped int
+	// Failed are the entities that could not be decoded or saved.
+	Failed []*LoadFailure
+	// Duration is the time spent decoding and saving the entities.
+	Duration time.Duration
+}
+
+// LoadFailure is an entity that could not be loaded.
+type LoadFailure struct {
+	// Index is the position of the entity in the input.
+	Index int
+	// Key is the entity key, or nil if it could not be decoded.
+	Key *datastore.Key
+	// Err is the decoding or datastore error.
+	Err error
+}
+
+// InterruptedError is returned by Dump and Load when they stop because
+// the context is done. The context is checked between batches, so the
+// batches already started are finished.
+type InterruptedError struct {
+	// Err is the context error.
+	Err error
+	// Next is the position of the first entity not loaded, that can be
+	// used as Options.Skip to resume the load, or the number of entities
+	// dumped.
+	Next int
+	// Cursor is the query cursor of the next entity to dump, that can be
+	// used as Options.Start to resume the dump. Not used when loading.
+	Cursor string
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("aetools: interrupted at entity %d: %v", e.Next, e.Err)
+}
+
+func (f *LoadFailure) Error() string {
+	if f.Key == nil {
+		return fmt.Sprintf("aetools: entity %d: %v", f.Index, f.Err)
+	}
+	return fmt.Sprintf("aetools: entity %d (%v): %v", f.Index, f.Key, f.Err)
 }
 
 // Load reads the JSON representation of entities from the io.Reader "r",
 // and stores them in the Datastore using the given context.Context.
-// The Options parameter allows you to configure how the dump will work.
-// If there is any parsing erros, improper format, or datastore failures
-// during the process, that error is returned and processing stops. The
-// error may be returned after some entities were loaded: there is no
-// parsing cache.
-func Load(c context.Context, r io.Reader, o *Options) error {
-	entities, err := DecodeEntities(c, r)
+// The Options parameter allows you to configure how the load will work.
+//
+// If the input is not a valid JSON array, an error is returned and no
+// entity is loaded. By default, if any entity can't be decoded, nothing
+// is saved, and if a batch fails to be saved, processing stops, returning
+// the error of the first failed entity: the previous batches are already
+// saved. When o.ContinueOnError is set, all other entities are saved, and
+// an error is returned at the end if any entity failed. In both cases,
+// the returned LoadResult reports which entities were saved and which
+// failed.
+//
+// If c is done, Load starts no more batches, waits for the running ones,
+// and returns an InterruptedError with the position to resume from. With
+// o.Workers greater than one, some entities after that position may be
+// already saved.
+func Load(c context.Context, r io.Reader, o *Options) (*LoadResult, error) {
+	result := new(LoadResult)
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	a, err := parseJSONArray(r)
 	if err != nil {
-		return err
+		return result, err
+	}
+	result.Read = len(a)
+	entities := make([]Entity, 0, len(a))
+	index := make([]int, 0, len(a))
+	for i, v := range a {
+		if i < o.Skip {
+			result.Skipped++
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			err = ErrInvalidElementType
+		} else {
+			var e *Entity
+			if e, err = decodeEntity(c, m); err == nil {
+				entities = append(entities, *e)
+				index = append(index, i)
+				continue
+			}
+		}
+		result.Failed = append(result.Failed, &LoadFailure{Index: i, Err: err})
+		if !o.ContinueOnError {
+			result.Skipped = result.Read - 1
+			return result, result.Failed[0]
+		}
+	}
+	err = loadEntities(c, entities, index, o, result)
+	sort.Sort(byIndex(result.Failed))
+	return result, err
+}
+
+// byIndex sorts load failures by the entity position.
+type byIndex []*LoadFailure
+
+func (b byIndex) Len() int           { return len(b) }
+func (b byIndex) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byIndex) Less(i, j int) bool { return b[i].Index < b[j].Index }
+
+// loadEntities saves entities in batches, as configured by o, updating
+// result. The position of each entity in the input is in index.
+//
+// Batches are started in order by up to o.Workers goroutines, and the
+// results are merged in batch order after all of them finish, so the
+// returned error is aways from the first failed batch. After a failure,
+// only the batches after the failed one are skipped, so all batches
+// before the first failure are saved. No more batches are started after
+// c is done.
+func loadEntities(c context.Context, entities []Entity, index []int, o *Options, result *LoadResult) error {
+	if o.Skip > 0 {
+		n := 0
+		for i := range entities {
+			if index[i] >= o.Skip {
+				entities[n], index[n] = entities[i], index[i]
+				n++
+			}
+		}
+		result.Skipped += len(entities) - n
+		entities, index = entities[:n], index[:n]
 	}
 	if len(entities) == 0 {
 		log.Infof(c, "Skipping load of 0 entities")
-		return nil
+		return loadError(result)
 	}
 	batchSize := o.BatchSize
 	if batchSize <= 0 {
 		batchSize = 50
 	}
-	for start, end := 0, 0; start < len(entities); {
-		end += batchSize
+	workers := o.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var batches []loadBatch
+	for start := 0; start < len(entities); start += batchSize {
+		end := start + batchSize
 		if end > len(entities) {
 			end = len(entities)
 		}
-		keys := make([]*datastore.Key, 0, end-start)
-		values := make([]datastore.PropertyList, 0, cap(keys))
+		b := loadBatch{start: start, end: end}
+		if o.SerializeGroups {
+			b.groups = entityGroups(entities[start:end])
+		}
+		batches = append(batches, b)
+	}
 
-		for _, e := range entities[start:end] {
-			keys = append(keys, e.Key)
-			values = append(values, e.Properties)
+	var (
+		mu   sync.Mutex
+		cond = sync.NewCond(&mu)
+		// failed is the index of the first failed batch
+		failed = len(batches)
+		busy   = make(map[string]bool)
+		work   = make(chan int)
+		wg     sync.WaitGroup
+		l      = newLimiter(o)
+		p      = newProgress(o, int64(len(entities)))
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				b := &batches[i]
+				mu.Lock()
+				skip := i > failed || c.Err() != nil
+				mu.Unlock()
+				if !skip {
+					b.put(c, entities, index, o, l)
+				}
+				mu.Lock()
+				if b.failed() && !o.ContinueOnError && i < failed {
+					failed = i
+				}
+				for _, g := range b.groups {
+					delete(busy, g)
+				}
+				if b.done {
+					p.addBytes(b.bytes)
+					p.batch(b.written)
+				}
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range batches {
+		mu.Lock()
+		// Wait for the batches writing to the same entity groups
+		for failed > i && batches[i].conflicts(busy) {
+			cond.Wait()
 		}
+		if failed < i || c.Err() != nil {
+			mu.Unlock()
+			break
+		}
+		for _, g := range batches[i].groups {
+			busy[g] = true
+		}
+		mu.Unlock()
+		work <- i
+	}
+	close(work)
+	wg.Wait()
 
-		keys, err = datastore.PutMulti(c, keys, values)
-		if err != nil {
-			return err
+	var (
+		err  error
+		next = -1
+	)
+	for i := range batches {
+		b := &batches[i]
+		if !b.done {
+			if next < 0 {
+				next = index[b.start]
+			}
+			result.Skipped += b.end - b.start
+			continue
+		}
+		result.Written += b.written
+		result.Failed = append(result.Failed, b.failures...)
+		if err == nil && b.failed() && !o.ContinueOnError {
+			err = b.err
+			if len(b.failures) > 0 {
+				err = b.failures[0]
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if next >= 0 && c.Err() != nil {
+		return &InterruptedError{Err: c.Err(), Next: next}
+	}
+	return loadError(result)
+}
+
+// loadBatch is a slice of the entities saved with a single PutMulti call.
+type loadBatch struct {
+	start, end int
+	// groups are the root keys of the entities in the batch.
+	groups []string
+
+	done     bool
+	written  int
+	bytes    int64
+	failures []*LoadFailure
+	// err is the error reading the entities after saving them.
+	err error
+}
+
+// put saves the entities in the batch, recording the results.
+func (b *loadBatch) put(c context.Context, entities []Entity, index []int, o *Options, l *limiter) {
+	s := StoreFromContext(c)
+	batch := entities[b.start:b.end]
+	keys := make([]*datastore.Key, 0, len(batch))
+	retry := &backoff{max: o.Retries}
+	for _, e := range batch {
+		keys = append(keys, e.Key)
+		if e.Key.Incomplete() {
+			retry.max = 0
 		}
-		log.Infof(c, "Loaded %d entities ...", len(keys))
+	}
 
-		if o.GetAfterPut {
-			log.Infof(c, "Making a read to force consistency ...")
-			l := make([]Entity, len(keys))
-			err := datastore.GetMulti(c, keys, l)
-			if err != nil {
-				return err
+	if l.wait(c, len(batch)) != nil {
+		// Interrupted before the batch started
+		return
+	}
+	var (
+		saved []*datastore.Key
+		err   error
+	)
+	for {
+		saved, err = s.PutMulti(c, keys, batch)
+		if err == nil || retry.wait(c, err) != nil {
+			break
+		}
+		if err = l.wait(c, len(batch)); err != nil {
+			break
+		}
+	}
+	me, _ := err.(appengine.MultiError)
+	written := make([]*datastore.Key, 0, len(batch))
+	for i := range batch {
+		switch {
+		case err == nil:
+			written = append(written, saved[i])
+		case me != nil && me[i] == nil:
+			written = append(written, batch[i].Key)
+		default:
+			f := &LoadFailure{Index: index[b.start+i], Key: batch[i].Key, Err: err}
+			if me != nil {
+				f.Err = me[i]
 			}
+			b.failures = append(b.failures, f)
 		}
+	}
+	b.done, b.written = true, len(written)
+	if o.Progress != nil {
+		for i := range batch {
+			if err == nil || me != nil && me[i] == nil {
+				b.bytes += EntitySize(&batch[i])
+			}
+		}
+	}
+	log.Infof(c, "Loaded %d entities ...", len(written))
+
+	if o.GetAfterPut && len(written) > 0 {
+		log.Infof(c, "Making a read to force consistency ...")
+		dst := make([]Entity, len(written))
+		retry := &backoff{max: o.Retries}
+		for {
+			if err = l.wait(c, len(written)); err == nil {
+				err = s.GetMulti(c, written, dst)
+			}
+			if err == nil || retry.wait(c, err) != nil {
+				break
+			}
+		}
+		if err != nil {
+			if !o.ContinueOnError {
+				b.err = err
+				return
+			}
+			log.Warningf(c, "Error reading loaded entities: %v", err)
+		}
+	}
+}
 
-		start = end
+// failed reports if any entity in the batch failed.
+func (b *loadBatch) failed() bool {
+	return len(b.failures) > 0 || b.err != nil
+}
+
+// conflicts reports if any entity group of the batch is in busy.
+func (b *loadBatch) conflicts(busy map[string]bool) bool {
+	for _, g := range b.groups {
+		if busy[g] {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+// entityGroups returns the distinct root keys of the entities, as strings.
+// Incomplete root keys are ignored, as they aways create a new group.
+func entityGroups(entities []Entity) []string {
+	seen := make(map[string]bool)
+	var groups []string
+	for _, e := range entities {
+		root := e.Key
+		for root != nil && root.Parent() != nil {
+			root = root.Parent()
+		}
+		if root == nil || root.Incomplete() {
+			continue
+		}
+		if g := root.String(); !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// loadError returns an error summarizing the failures in result, if any.
+func loadError(result *LoadResult) error {
+	switch len(result.Failed) {
+	case 0:
+		return nil
+	case 1:
+		return result.Failed[0]
+	}
+	return fmt.Errorf("aetools: %d of %d entities failed to load, first error: %v",
+		len(result.Failed), result.Read, result.Failed[0])
 }
 
 // DumpJSON is a convenient wrapper that captures the generated JSON from Dump
@@ -148,32 +551,97 @@ func DumpJSON(c context.Context, o *Options) (string, error) {
 // how the dump will run by using the Options parameter. If there is an error
 // generating the output, or writting to the writer, it is returned. This method
 // may return an error after writting bytes to w: the output is not buffered.
+//
+// If c is done, Dump stops before the next batch, closing the JSON array
+// with the entities already written, and returns an InterruptedError with
+// the cursor to resume from.
 func Dump(c context.Context, w io.Writer, o *Options) error {
 	var (
-		comma        = []byte(",")
 		openBracket  = []byte("[")
+		separator    = []byte(",\n")
 		closeBracket = []byte("]")
-		lineFeed     = []byte("\n")
-		indent       = "  "
 	)
+	if o.Canonical {
+		openBracket, separator, closeBracket = canonicalOpen, canonicalSeparator, canonicalClose
+	}
 
+	var p *progress
+	if o.Progress != nil {
+		p = newProgress(o, kindCount(c, o.Kind))
+	}
 	w.Write(openBracket)
+	count := 0
+	err := dumpBatches(c, o, o.Start, p, func(e *Entity) error {
+		if count > 0 {
+			w.Write(separator)
+			p.addBytes(int64(len(separator)))
+		}
+		b, err := o.marshal(e)
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+		p.addBytes(int64(len(b)))
+		count++
+		return nil
+	})
+	if _, ok := err.(*InterruptedError); err != nil && !ok {
+		return err
+	}
+	if o.Canonical && count == 0 {
+		closeBracket = []byte("]\n")
+	}
+	w.Write(closeBracket)
+	return err
+}
+
+// dumpEntities queries the entities of o.Kind in key order and
+// calls f for each one, restarting the query after each batch.
+// A batch that fails with a transient error is restarted, skipping
+// the entities already passed to f.
+func dumpEntities(c context.Context, o *Options, f func(e *Entity) error) error {
+	return dumpBatches(c, o, "", nil, f)
+}
+
+// dumpBatches is like dumpEntities, starting at the cursor start and
+// reporting each batch to p. It returns an InterruptedError if c is done
+// before a batch starts.
+func dumpBatches(c context.Context, o *Options, start string, p *progress, f func(e *Entity) error) error {
 	count := 0
 	last := 0
+	skip := 0
 	batchSize := o.BatchSize
 	if batchSize <= 0 {
 		batchSize = 100
 	}
 	log.Infof(c, "dump: using batch size %d, kind %s", batchSize, o.Kind)
-	q := datastore.NewQuery(o.Kind).Order("__key__").Limit(batchSize)
-	for i := q.Run(c); ; {
+	s := StoreFromContext(c)
+	l := newLimiter(o)
+	retry := &backoff{max: o.Retries}
+	q := &Query{Kind: o.Kind, Orders: []string{"__key__"}, Limit: batchSize, Start: start}
+	begin := func() error {
+		err := c.Err()
+		if err == nil {
+			err = l.wait(c, batchSize)
+		}
+		if err != nil {
+			return &InterruptedError{Err: err, Next: count, Cursor: q.Start}
+		}
+		return nil
+	}
+	if err := begin(); err != nil {
+		return err
+	}
+	for i := s.Run(c, q); ; {
 		var e Entity
-		k, err := i.Next(&e)
-		e.Key = k
+		_, err := i.Next(&e)
 		if err == datastore.Done {
 			log.Infof(c, "datastore.Done: last=%d, count=%d", last, count)
+			if count > last {
+				p.batch(count - last)
+			}
 			if last == count || count-last < batchSize {
-				break
+				return nil
 			}
 			// This 100 batch is done, but more can be found in the next one
 			last = count
@@ -182,60 +650,59 @@ func Dump(c context.Context, w io.Writer, o *Options) error {
 				return err
 			}
 			log.Infof(c, "restarting the query: cursor=%v", cur)
-			i = datastore.NewQuery(o.Kind).Order("__key__").Limit(batchSize).Start(cur).Run(c)
+			q.Start = cur
+			retry = &backoff{max: o.Retries}
+			if err := begin(); err != nil {
+				return err
+			}
+			i = s.Run(c, q)
 			continue
 		}
 		if err != nil {
-			return err
-		}
-		if count > 0 {
-			w.Write(comma)
-			w.Write(lineFeed)
+			if err := retry.wait(c, err); err != nil {
+				return err
+			}
+			// Read the batch again, from the same cursor
+			skip = count - last
+			i = s.Run(c, q)
+			continue
 		}
-		var b []byte
-		if o.PrettyPrint {
-			b, err = json.MarshalIndent(&e, "", indent)
-		} else {
-			b, err = json.Marshal(&e)
+		if skip > 0 {
+			skip--
+			continue
 		}
-		if err != nil {
+		if err := f(&e); err != nil {
 			return err
 		}
-		w.Write(b)
 		count++
 	}
-	w.Write(closeBracket)
-	return nil
 }
 
-//DumpEntity export a single entity from the context
+// DumpEntity exports a single entity from the context. The key can be
+// in any of the forms accepted by ParseKey.
 func DumpEntity(c context.Context, w io.Writer, keyString string, o *Options) error {
 	var (
 		openBracket  = []byte("[")
 		closeBracket = []byte("]")
-		indent       = "  "
 	)
+	if o.Canonical {
+		openBracket, closeBracket = canonicalOpen, canonicalClose
+	}
 
-	w.Write(openBracket)
-
-	key, err := datastore.DecodeKey(keyString)
+	key, err := ParseKey(c, keyString)
 	if err != nil {
 		return err
 	}
 	log.Infof(c, "dump: using decoded key: %#v", key)
 
-	var e Entity
-	if err := datastore.Get(c, key, &e); err != nil {
+	w.Write(openBracket)
+
+	e, err := Get(c, key)
+	if err != nil {
 		return err
 	}
-	e.Key = key
 
-	var b []byte
-	if o.PrettyPrint {
-		b, err = json.MarshalIndent(&e, "", indent)
-	} else {
-		b, err = json.Marshal(&e)
-	}
+	b, err := o.marshal(e)
 	if err != nil {
 		return err
 	}
@@ -246,15 +713,30 @@ func DumpEntity(c context.Context, w io.Writer, keyString string, o *Options) er
 	return nil
 }
 
-// EncodeEntities serializes the parameter into a JSON string.
+// EncodeEntities serializes the parameter into a JSON array, using the
+// same layout of Dump.
 func EncodeEntities(entities []Entity, w io.Writer) error {
-	for i, e := range entities {
-		err := encodeEntity(e, w)
+	return encodeArray(w, entities, &Options{})
+}
+
+// encodeArray writes entities to w as a JSON array, with each entity
+// marshalled as specified by o.
+func encodeArray(w io.Writer, entities []Entity, o *Options) error {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := range entities {
+		if i > 0 {
+			b.WriteString(",\n")
+		}
+		e, err := o.marshal(&entities[i])
 		if err != nil {
 			return fmt.Errorf("aetools: Unable to encode position %d: %s", i, err.Error())
 		}
+		b.Write(e)
 	}
-	return nil
+	b.WriteString("]")
+	_, err := w.Write(b.Bytes())
+	return err
 }
 
 // DecodeEntities deserielizes the parameter from a JSON string
@@ -264,23 +746,7 @@ func DecodeEntities(c context.Context, r io.Reader) ([]Entity, error) {
 		return nil, err
 	}
 
-	var result []Entity
-
-	for _, i := range a {
-		m, ok := i.(map[string]interface{})
-		if !ok {
-			return nil, ErrInvalidElementType
-		}
-
-		e, err := decodeEntity(c, m)
-		if err != nil {
-			return nil, err
-		}
-
-		result = append(result, *e)
-	}
-
-	return result, nil
+	return decodeEntities(c, a)
 }
 
 // parseJSONArray parses a JSON array and returns it's value.
@@ -304,16 +770,6 @@ func parseJSONArray(r io.Reader) ([]interface{}, error) {
 	return a, nil
 }
 
-// encodeEntity serializes the given Entity into the provided writer.
-func encodeEntity(e Entity, w io.Writer) error {
-	b, err := e.MarshalJSON()
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(b)
-	return err
-}
-
 // decodeEntity decodes the map as an Entity struct.
 func decodeEntity(c context.Context, m map[string]interface{}) (*Entity, error) {
 	var e Entity
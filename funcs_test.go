@@ -5,12 +5,15 @@ package aetools
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/drhodes/golorem"
 	"golang.org/x/net/context"
-	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -30,13 +33,10 @@ func TestEndToEndTest(t *testing.T) {
 		t.Skip()
 	}
 
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
-	err = createSampleEntities(c, 3)
+	err := createSampleEntities(c, 3)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -48,7 +48,7 @@ func TestEndToEndTest(t *testing.T) {
 	}
 	t.Log("Dump output: ", w)
 
-	err = Load(c, w, &Options{
+	_, err = Load(c, w, &Options{
 		GetAfterPut: true,
 		BatchSize:   50,
 	})
@@ -58,14 +58,12 @@ func TestEndToEndTest(t *testing.T) {
 }
 
 func TestEncodeEntities(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
 	parent := datastore.NewKey(c, "Parent", "parent-1", 0, nil)
 
+	var err error
 	entities := make([]Entity, 0, 10)
 	for i := 0; i < 10; i++ {
 		id := i + 1
@@ -109,6 +107,13 @@ func TestEncodeEntities(t *testing.T) {
 
 	json := w.String()
 	t.Logf("JSON encoded entities: %s", json)
+	decoded, err := DecodeEntities(c, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(entities) {
+		t.Errorf("Unexpected decoded entities: %d, expected %d", len(decoded), len(entities))
+	}
 	attrs := []string{"name", "tags", "active", "height"}
 	for _, a := range attrs {
 		if !strings.Contains(json, a) {
@@ -118,10 +123,7 @@ func TestEncodeEntities(t *testing.T) {
 }
 
 func TestDecodeEntities(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
 	r, err := DecodeEntities(c, bytes.NewReader(fixture))
@@ -140,13 +142,10 @@ func TestDecodeEntities(t *testing.T) {
 }
 
 func TestLoadFixtures(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
-	err = Load(c, bytes.NewReader(fixture), &Options{GetAfterPut: true})
+	_, err := Load(c, bytes.NewReader(fixture), &Options{GetAfterPut: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -155,7 +154,10 @@ func TestLoadFixtures(t *testing.T) {
 	var ancestor *datastore.Key
 	k := datastore.NewKey(c, "Profile", "", 123456, ancestor)
 	var p Profile
-	err = datastore.Get(c, k, &p)
+	e, err := Get(c, k)
+	if err == nil {
+		err = datastore.LoadStruct(&p, e.Properties)
+	}
 	if err != nil {
 		t.Errorf("Unable to load entity by key. LoadFixture failed: %s", err.Error())
 		t.FailNow()
@@ -187,10 +189,7 @@ func TestLoadFixtures(t *testing.T) {
 }
 
 func TestBatchSizeOnDump(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
 	for _, i := range []int{10, 20, 50, 99, 100, 101} {
@@ -220,14 +219,11 @@ func TestBatchSizeOnDump(t *testing.T) {
 }
 
 func TestBatchSizeWhenLoading(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
 	// Zero-case check for load bounds
-	if err := Load(c, strings.NewReader("[]"), LoadSync); err != nil {
+	if _, err := Load(c, strings.NewReader("[]"), LoadSync); err != nil {
 		t.Errorf("Failed to load empty array: %v", err)
 	}
 
@@ -246,11 +242,11 @@ func TestBatchSizeWhenLoading(t *testing.T) {
 		}
 		fmt.Fprintf(json, `{"__key__" : ["Test%d", 0]}]`, i)
 		t.Logf("Loading %d entities ...", i)
-		err := Load(c, json, LoadSync)
+		_, err := Load(c, json, LoadSync)
 		if err != nil {
 			t.Errorf("Error loaing %d entities: %v", i, err)
 		}
-		if count, err := datastore.NewQuery(fmt.Sprintf("Test%d", i)).Count(c); err != nil {
+		if count, err := countEntities(c, fmt.Sprintf("Test%d", i)); err != nil {
 			t.Errorf("Error checking the persisted entities: %v", err)
 		} else if count != i {
 			t.Errorf("Entity count minsmatch: %d, expected %d", count, i)
@@ -259,6 +255,7 @@ func TestBatchSizeWhenLoading(t *testing.T) {
 }
 
 func createSampleEntities(c context.Context, size int) error {
+	s := StoreFromContext(c)
 	buff := make([]Entity, 0, 10)
 	keys := make([]*datastore.Key, 0, 10)
 	for i := 1; i <= size; i++ {
@@ -304,22 +301,313 @@ func createSampleEntities(c context.Context, size int) error {
 		keys = append(keys, k)
 
 		if len(buff) == 10 {
-			_, err := datastore.PutMulti(c, keys, buff)
+			_, err := s.PutMulti(c, keys, buff)
 			if err != nil {
 				return err
 			}
-			_ = datastore.GetMulti(c, keys, buff)
+			_ = s.GetMulti(c, keys, buff)
 
 			buff = make([]Entity, 0, 10)
 			keys = make([]*datastore.Key, 0, 10)
 		}
 	}
 	if len(buff) > 0 {
-		k, err := datastore.PutMulti(c, keys, buff)
+		k, err := s.PutMulti(c, keys, buff)
 		if err != nil {
 			return err
 		}
-		_ = datastore.GetMulti(c, k, buff)
+		_ = s.GetMulti(c, k, buff)
 	}
 	return nil
 }
+
+// countEntities counts the entities of the given kind with a keys only query.
+func countEntities(c context.Context, kind string) (int, error) {
+	count := 0
+	it := StoreFromContext(c).Run(c, &Query{Kind: kind, KeysOnly: true})
+	for {
+		_, err := it.Next(nil)
+		if err == datastore.Done {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// failingStore is a MemoryStore that fails to save entities with
+// string IDs starting with "bad".
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) PutMulti(c context.Context, keys []*datastore.Key, src []Entity) ([]*datastore.Key, error) {
+	var (
+		me     = make(appengine.MultiError, len(keys))
+		failed bool
+		ok     []int
+	)
+	for i, k := range keys {
+		if strings.HasPrefix(k.StringID(), "bad") {
+			me[i], failed = errors.New("bad entity"), true
+		} else {
+			ok = append(ok, i)
+		}
+	}
+	for _, i := range ok {
+		if _, err := s.MemoryStore.PutMulti(c, keys[i:i+1], src[i:i+1]); err != nil {
+			return nil, err
+		}
+	}
+	if failed {
+		return nil, me
+	}
+	return keys, nil
+}
+
+func TestLoadResult(t *testing.T) {
+	fixture := `[
+		{"__key__": ["User", 1]},
+		{"__key__": ["User", 2]},
+		{"__key__": ["User", "bad"]},
+		{"__key__": ["User", 3]},
+		{"__key__": ["User", 4]},
+		{"__key__": ["User", 5]}
+	]`
+	invalid := strings.Replace(fixture, `{"__key__": ["User", 4]}`, `{"__key__": "User,4"}`, 1)
+	cases := []struct {
+		name      string
+		fixture   string
+		keepGoing bool
+		written   int
+		skipped   int
+		failed    []int
+	}{
+		{"stop at decode error", invalid, false, 0, 5, []int{4}},
+		{"stop at put error", fixture, false, 3, 2, []int{2}},
+		{"continue on errors", invalid, true, 4, 0, []int{2, 4}},
+	}
+	for _, tc := range cases {
+		c := WithStore(OfflineContext(context.Background()), failingStore{NewMemoryStore()})
+		r, err := Load(c, strings.NewReader(tc.fixture), &Options{BatchSize: 2, ContinueOnError: tc.keepGoing})
+		if err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+		if r.Read != 6 || r.Written != tc.written || r.Skipped != tc.skipped || len(r.Failed) != len(tc.failed) {
+			t.Errorf("%s: unexpected result %+v", tc.name, r)
+			continue
+		}
+		for i, f := range r.Failed {
+			if f.Index != tc.failed[i] {
+				t.Errorf("%s: unexpected failure %d: %v, expected index %d", tc.name, i, f, tc.failed[i])
+			}
+		}
+		if count, _ := countEntities(c, "User"); count != tc.written {
+			t.Errorf("%s: unexpected saved entities: %d, expected %d", tc.name, count, tc.written)
+		}
+	}
+}
+
+// trackingStore is a MemoryStore that records the maximum number of
+// concurrent PutMulti calls, and if an entity group was saved by two
+// concurrent calls.
+type trackingStore struct {
+	*MemoryStore
+	mu       sync.Mutex
+	busy     map[string]bool
+	running  int
+	max      int
+	overlaps int
+}
+
+func (s *trackingStore) PutMulti(c context.Context, keys []*datastore.Key, src []Entity) ([]*datastore.Key, error) {
+	groups := entityGroups(src)
+	s.mu.Lock()
+	s.running++
+	if s.running > s.max {
+		s.max = s.running
+	}
+	for _, g := range groups {
+		if s.busy[g] {
+			s.overlaps++
+		}
+		s.busy[g] = true
+	}
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	s.running--
+	for _, g := range groups {
+		delete(s.busy, g)
+	}
+	s.mu.Unlock()
+	return s.MemoryStore.PutMulti(c, keys, src)
+}
+
+func TestLoadWorkers(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 0; i < 200; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		// Ten entity groups, with the entities of each group spread
+		// over all batches.
+		fmt.Fprintf(&b, `{"__key__": ["Group", %d, "Item", %d]}`, i%10+1, i+1)
+	}
+	b.WriteString("]")
+	fixture := b.String()
+
+	for _, serialize := range []bool{false, true} {
+		s := &trackingStore{MemoryStore: NewMemoryStore(), busy: make(map[string]bool)}
+		c := WithStore(OfflineContext(context.Background()), s)
+		o := &Options{BatchSize: 5, Workers: 4, SerializeGroups: serialize}
+		r, err := Load(c, strings.NewReader(fixture), o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Written != 200 || s.Len() != 200 {
+			t.Errorf("serialize=%v: unexpected entities written: %d, saved %d", serialize, r.Written, s.Len())
+		}
+		if s.max < 2 {
+			t.Errorf("serialize=%v: batches were not saved concurrently", serialize)
+		}
+		if serialize && s.overlaps > 0 {
+			t.Errorf("serialize=%v: %d batches saved the same entity group concurrently", serialize, s.overlaps)
+		}
+	}
+}
+
+func TestLoadWorkersErrors(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		if i%30 == 29 {
+			fmt.Fprintf(&b, `{"__key__": ["User", "bad%d"]}`, i)
+		} else {
+			fmt.Fprintf(&b, `{"__key__": ["User", %d]}`, i+1)
+		}
+	}
+	b.WriteString("]")
+	fixture := b.String()
+
+	for _, keepGoing := range []bool{false, true} {
+		var expected string
+		for _, workers := range []int{1, 2, 8} {
+			c := WithStore(OfflineContext(context.Background()), failingStore{NewMemoryStore()})
+			o := &Options{BatchSize: 3, Workers: workers, ContinueOnError: keepGoing}
+			r, err := Load(c, strings.NewReader(fixture), o)
+			if err == nil {
+				t.Fatalf("workers=%d: expected error", workers)
+			}
+			if r.Read != r.Written+r.Skipped+len(r.Failed) {
+				t.Errorf("workers=%d: inconsistent result %+v", workers, r)
+			}
+			if keepGoing && (r.Written != 97 || len(r.Failed) != 3) {
+				t.Errorf("workers=%d: unexpected result %+v", workers, r)
+			}
+			if expected == "" {
+				expected = err.Error()
+			} else if err.Error() != expected {
+				t.Errorf("workers=%d: unexpected error %v, expected %s", workers, err, expected)
+			}
+		}
+	}
+}
+
+func TestLoadWorkersLaterBatchFailsFirst(t *testing.T) {
+	fixture := `[{"__key__": ["User", 1]}, {"__key__": ["User", 2]}, {"__key__": ["User", 3]}, {"__key__": ["User", "bad4"]}]`
+	// With a single P, the worker woken last runs first, so the last
+	// batch fails before the others are started
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	for n := 0; n < 200; n++ {
+		c := WithStore(OfflineContext(context.Background()), failingStore{NewMemoryStore()})
+		o := &Options{BatchSize: 1, Workers: 4}
+		r, err := Load(c, strings.NewReader(fixture), o)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		// The batches before the failed one are always written
+		if r.Written != 3 || len(r.Failed) != 1 || r.Failed[0].Index != 3 {
+			t.Fatalf("run %d: unexpected result %+v", n, r)
+		}
+		if r.Read != r.Written+r.Skipped+len(r.Failed) {
+			t.Fatalf("run %d: inconsistent result %+v", n, r)
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 1; i <= 10; i++ {
+		if i > 1 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"__key__": ["User", %d]}`, i)
+	}
+	b.WriteString("]")
+	fixture := b.String()
+
+	store := NewMemoryStore()
+	c, cancel := context.WithCancel(WithStore(OfflineContext(context.Background()), store))
+	o := &Options{BatchSize: 3, Progress: func(s Stats) {
+		if s.Batches == 2 {
+			cancel()
+		}
+	}}
+	r, err := Load(c, strings.NewReader(fixture), o)
+	ie, ok := err.(*InterruptedError)
+	if !ok {
+		t.Fatalf("Unexpected error %v, expected an InterruptedError", err)
+	}
+	if ie.Next != 6 || r.Written != 6 || r.Skipped != 4 || store.Len() != 6 {
+		t.Errorf("Unexpected interrupted load: %v, %+v", ie, r)
+	}
+
+	// Resume the load
+	c = WithStore(OfflineContext(context.Background()), store)
+	r, err = Load(c, strings.NewReader(fixture), &Options{BatchSize: 3, Skip: ie.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Written != 4 || r.Skipped != 6 || store.Len() != 10 {
+		t.Errorf("Unexpected resumed load: %+v", r)
+	}
+
+	// Interrupt and resume a dump
+	c, cancel = context.WithCancel(c)
+	o = &Options{Kind: "User", BatchSize: 4, Progress: func(s Stats) { cancel() }}
+	var first, second bytes.Buffer
+	err = Dump(c, &first, o)
+	if ie, ok = err.(*InterruptedError); !ok {
+		t.Fatalf("Unexpected error %v, expected an InterruptedError", err)
+	}
+	if ie.Next != 4 || ie.Cursor == "" {
+		t.Errorf("Unexpected interrupted dump: %+v", ie)
+	}
+	c = WithStore(OfflineContext(context.Background()), store)
+	if err := Dump(c, &second, &Options{Kind: "User", BatchSize: 4, Start: ie.Cursor}); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, out := range []*bytes.Buffer{&first, &second} {
+		entities, err := DecodeEntities(c, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entities {
+			ids = append(ids, e.Key.IntID())
+		}
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5 6 7 8 9 10]" {
+		t.Errorf("Unexpected entities dumped: %v", ids)
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// ParseGQL parses a query in a practical subset of GQL, the datastore
+// query language:
+//
+//	SELECT * | __key__ | property, ... FROM Kind
+//	[WHERE condition [AND condition]...]
+//	[ORDER BY property [ASC | DESC], ...]
+//	[LIMIT n] [OFFSET m]
+//
+// Conditions compare a property with a value using =, <, <=, >, or >=,
+// test if a property IS NULL, or restrict the results to the descendants
+// of a key with "__key__ HAS ANCESTOR KEY(...)". Values are strings in
+// single or double quotes, integers, floats, TRUE, FALSE, NULL, dates as
+// DATETIME('2006-01-02T15:04:05Z') and keys as KEY(Kind, id, ...) or
+// KEY('key'), with a key in any form accepted by ParseKey. Keywords are
+// case insensitive, and names may be quoted with backticks.
+//
+// SELECT __key__ returns a keys only query. Since Query has no projections,
+// the properties listed in the SELECT clause are returned, so the caller
+// can select them from the results; they are empty for SELECT * and
+// SELECT __key__.
+func ParseGQL(c context.Context, gql string) (*Query, []string, error) {
+	toks, err := lexGQL(gql)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &gqlParser{c: c, toks: toks}
+	return p.parse()
+}
+
+// Kinds of GQL tokens.
+const (
+	gqlEOF = iota
+	gqlName
+	gqlQuotedName
+	gqlString
+	gqlNumber
+	gqlSymbol
+)
+
+// gqlToken is a token of a GQL query, at the byte offset pos.
+type gqlToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t gqlToken) String() string {
+	if t.kind == gqlEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q at position %d", t.text, t.pos)
+}
+
+// lexGQL splits the GQL query s into tokens.
+func lexGQL(s string) ([]gqlToken, error) {
+	var toks []gqlToken
+	for i := 0; i < len(s); {
+		ch, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(ch):
+			i += size
+		case ch == '_' || unicode.IsLetter(ch):
+			j := i
+			for j < len(s) {
+				r, n := utf8.DecodeRuneInString(s[j:])
+				if !isGQLNameChar(r) {
+					break
+				}
+				j += n
+			}
+			toks = append(toks, gqlToken{gqlName, s[i:j], i})
+			i = j
+		case ch == '-' || ch == '.' || unicode.IsDigit(ch):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || strings.ContainsRune(".eE", rune(s[j])) ||
+				(strings.ContainsRune("+-", rune(s[j])) && strings.ContainsRune("eE", rune(s[j-1])))) {
+				j++
+			}
+			toks = append(toks, gqlToken{gqlNumber, s[i:j], i})
+			i = j
+		case ch == '\'' || ch == '"' || ch == '`':
+			text, n, err := unquoteGQL(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("aetools: invalid GQL query: %v at position %d", err, i)
+			}
+			kind := gqlString
+			if ch == '`' {
+				kind = gqlQuotedName
+			}
+			toks = append(toks, gqlToken{kind, text, i})
+			i += n
+		default:
+			j := i + size
+			if j < len(s) && strings.ContainsRune("<>!", ch) && s[j] == '=' {
+				j++
+			}
+			sym := s[i:j]
+			switch sym {
+			case "*", ",", "(", ")", "=", "<", "<=", ">", ">=", "!=", ";":
+			default:
+				return nil, fmt.Errorf("aetools: invalid GQL query: unexpected %q at position %d", sym, i)
+			}
+			toks = append(toks, gqlToken{gqlSymbol, sym, i})
+			i = j
+		}
+	}
+	return append(toks, gqlToken{kind: gqlEOF, pos: len(s)}), nil
+}
+
+// isGQLNameChar reports if ch can be used in an unquoted name.
+func isGQLNameChar(ch rune) bool {
+	return ch == '_' || ch == '.' || unicode.IsLetter(ch) || unicode.IsDigit(ch)
+}
+
+// unquoteGQL returns the text quoted at the start of s, and the length
+// of the quoted text. The quote is escaped by repeating it or with a
+// backslash, which also escapes itself.
+func unquoteGQL(s string) (string, int, error) {
+	q := s[0]
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b = append(b, s[i])
+			}
+		case q:
+			if i+1 < len(s) && s[i+1] == q {
+				i++
+				b = append(b, q)
+				continue
+			}
+			return string(b), i + 1, nil
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated %c quote", q)
+}
+
+// gqlParser parses the tokens of a GQL query.
+type gqlParser struct {
+	c    context.Context
+	toks []gqlToken
+	pos  int
+}
+
+// keyword consumes the given keywords, if they are the next tokens.
+func (p *gqlParser) keyword(words ...string) bool {
+	for i, w := range words {
+		t := p.toks[p.pos+i]
+		if t.kind != gqlName || !strings.EqualFold(t.text, w) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+// symbol consumes the symbol s, if it is the next token.
+func (p *gqlParser) symbol(s string) bool {
+	if t := p.toks[p.pos]; t.kind == gqlSymbol && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// errorf returns an error at the next token.
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("aetools: invalid GQL query: %s, found %v", fmt.Sprintf(format, args...), p.toks[p.pos])
+}
+
+// expect consumes the keywords, or returns an error.
+func (p *gqlParser) expect(words ...string) error {
+	if !p.keyword(words...) {
+		return p.errorf("expected %s", strings.Join(words, " "))
+	}
+	return nil
+}
+
+// name returns the next name, quoted or not. Keywords are valid
+// names, since names are never optional.
+func (p *gqlParser) name(what string) (string, error) {
+	t := p.toks[p.pos]
+	if t.kind != gqlName && t.kind != gqlQuotedName {
+		return "", p.errorf("expected %s", what)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *gqlParser) parse() (*Query, []string, error) {
+	q := new(Query)
+	var properties []string
+	if err := p.expect("SELECT"); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case p.symbol("*"):
+	case p.keyword("__key__"):
+		q.KeysOnly = true
+	default:
+		for {
+			n, err := p.name("property name or *")
+			if err != nil {
+				return nil, nil, err
+			}
+			properties = append(properties, n)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, nil, err
+	}
+	kind, err := p.name("kind")
+	if err != nil {
+		return nil, nil, err
+	}
+	q.Kind = kind
+	if p.keyword("WHERE") {
+		for {
+			if err := p.condition(q); err != nil {
+				return nil, nil, err
+			}
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+	if p.keyword("ORDER", "BY") {
+		for {
+			n, err := p.name("property name")
+			if err != nil {
+				return nil, nil, err
+			}
+			if p.keyword("DESC") {
+				n = "-" + n
+			} else {
+				p.keyword("ASC")
+			}
+			q.Orders = append(q.Orders, n)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	for _, clause := range []string{"LIMIT", "OFFSET"} {
+		if !p.keyword(clause) {
+			continue
+		}
+		t := p.toks[p.pos]
+		n, err := strconv.Atoi(t.text)
+		if t.kind != gqlNumber || err != nil || n < 0 {
+			return nil, nil, p.errorf("expected a non-negative integer after %s", clause)
+		}
+		p.pos++
+		if clause == "LIMIT" {
+			q.Limit = n
+		} else {
+			q.Offset = n
+		}
+	}
+	p.symbol(";")
+	if p.toks[p.pos].kind != gqlEOF {
+		return nil, nil, p.errorf("expected end of query")
+	}
+	return q, properties, nil
+}
+
+// condition parses a WHERE condition, adding it to q.
+func (p *gqlParser) condition(q *Query) error {
+	name, err := p.name("property name")
+	if err != nil {
+		return err
+	}
+	if p.keyword("HAS", "ANCESTOR") {
+		if name != "__key__" {
+			return p.errorf("HAS ANCESTOR is only valid for __key__")
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		k, ok := v.(*datastore.Key)
+		if !ok {
+			return p.errorf("expected the ancestor KEY")
+		}
+		q.Ancestor = k
+		return nil
+	}
+	if p.keyword("IS", "NULL") {
+		q.Filters = append(q.Filters, Filter{Property: name, Operator: "=", Value: nil})
+		return nil
+	}
+	t := p.toks[p.pos]
+	if !validOperator(t.text) || t.kind != gqlSymbol {
+		if t.text == "!=" || strings.EqualFold(t.text, "IN") {
+			return p.errorf("the %s operator is not supported", strings.ToUpper(t.text))
+		}
+		return p.errorf("expected an operator: =, <, <=, > or >=")
+	}
+	p.pos++
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	if _, ok := v.(*datastore.Key); name == "__key__" && !ok {
+		return p.errorf("__key__ must be compared to a KEY")
+	}
+	q.Filters = append(q.Filters, Filter{Property: name, Operator: t.text, Value: v})
+	return nil
+}
+
+// gqlDateLayouts are the layouts accepted by DATETIME. Dates
+// without a time zone are in UTC.
+var gqlDateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// value parses a literal value.
+func (p *gqlParser) value() (interface{}, error) {
+	t := p.toks[p.pos]
+	switch {
+	case t.kind == gqlString:
+		p.pos++
+		return t.text, nil
+	case t.kind == gqlNumber:
+		p.pos++
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.pos--
+			return nil, p.errorf("invalid number")
+		}
+		return f, nil
+	case p.keyword("TRUE"):
+		return true, nil
+	case p.keyword("FALSE"):
+		return false, nil
+	case p.keyword("NULL"):
+		return nil, nil
+	case p.keyword("DATETIME"):
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 1 && args[0].kind == gqlString {
+			for _, layout := range gqlDateLayouts {
+				if d, err := time.Parse(layout, args[0].text); err == nil {
+					return d, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("aetools: invalid GQL query: invalid DATETIME at position %d", t.pos)
+	case p.keyword("KEY"):
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		k, err := p.key(args)
+		if err != nil {
+			return nil, fmt.Errorf("aetools: invalid GQL query: invalid KEY at position %d: %v", t.pos, err)
+		}
+		return k, nil
+	}
+	return nil, p.errorf("expected a value")
+}
+
+// args parses the parenthesized arguments of DATETIME and KEY.
+func (p *gqlParser) args() ([]gqlToken, error) {
+	if !p.symbol("(") {
+		return nil, p.errorf("expected (")
+	}
+	var args []gqlToken
+	for !p.symbol(")") {
+		if len(args) > 0 && !p.symbol(",") {
+			return nil, p.errorf("expected , or )")
+		}
+		t := p.toks[p.pos]
+		switch t.kind {
+		case gqlString, gqlNumber, gqlName, gqlQuotedName:
+			args = append(args, t)
+			p.pos++
+		default:
+			return nil, p.errorf("expected a string, number or name")
+		}
+	}
+	return args, nil
+}
+
+// key returns the key for the arguments of KEY.
+func (p *gqlParser) key(args []gqlToken) (*datastore.Key, error) {
+	if len(args) == 1 && args[0].kind == gqlString {
+		return ParseKey(p.c, args[0].text)
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, fmt.Errorf("expected kind and id pairs")
+	}
+	var k *datastore.Key
+	for i := 0; i < len(args); i += 2 {
+		kind, id := args[i], args[i+1]
+		if kind.kind == gqlNumber {
+			return nil, fmt.Errorf("invalid kind %s", kind.text)
+		}
+		switch id.kind {
+		case gqlNumber:
+			n, err := strconv.ParseInt(id.text, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid id %s", id.text)
+			}
+			k = datastore.NewKey(p.c, kind.text, "", n, k)
+		case gqlString:
+			k = datastore.NewKey(p.c, kind.text, id.text, 0, k)
+		default:
+			return nil, fmt.Errorf("invalid id %s, names must be quoted", id.text)
+		}
+	}
+	return k, nil
+}
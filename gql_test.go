@@ -0,0 +1,137 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestParseGQL(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	customer := datastore.NewKey(c, "Customer", "ana", 0, nil)
+	order := datastore.NewKey(c, "Order", "", 1, customer)
+	cases := []struct {
+		GQL        string
+		Query      *Query
+		Properties []string
+	}{
+		{"SELECT * FROM Order", &Query{Kind: "Order"}, nil},
+		{"select __key__ from `My Kind`;", &Query{Kind: "My Kind", KeysOnly: true}, nil},
+		{"SELECT customer, `total.value` FROM Order", &Query{Kind: "Order"}, []string{"customer", "total.value"}},
+		{
+			"SELECT * FROM Order WHERE status = 'open' AND created > DATETIME('2017-03-01T10:00:00Z') ORDER BY created LIMIT 100",
+			&Query{Kind: "Order", Filters: []Filter{
+				{Property: "status", Operator: "=", Value: "open"},
+				{Property: "created", Operator: ">", Value: time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)},
+			}, Orders: []string{"created"}, Limit: 100},
+			nil,
+		},
+		{
+			`SELECT * FROM Order WHERE total >= -2.5 AND count < 10 AND paid = TRUE AND note IS NULL AND name = "it's ""quoted"""`,
+			&Query{Kind: "Order", Filters: []Filter{
+				{Property: "total", Operator: ">=", Value: -2.5},
+				{Property: "count", Operator: "<", Value: int64(10)},
+				{Property: "paid", Operator: "=", Value: true},
+				{Property: "note", Operator: "=", Value: nil},
+				{Property: "name", Operator: "=", Value: `it's "quoted"`},
+			}},
+			nil,
+		},
+		{
+			"SELECT * FROM Order WHERE __key__ HAS ANCESTOR KEY(Customer, 'ana') AND __key__ > KEY('Customer,ana,Order,1') ORDER BY total DESC, customer ASC LIMIT 5 OFFSET 10",
+			&Query{Kind: "Order", Ancestor: customer, Filters: []Filter{
+				{Property: "__key__", Operator: ">", Value: order},
+			}, Orders: []string{"-total", "customer"}, Limit: 5, Offset: 10},
+			nil,
+		},
+		{"SELECT nome FROM Usuário WHERE situação = 'ativo'", &Query{Kind: "Usuário", Filters: []Filter{
+			{Property: "situação", Operator: "=", Value: "ativo"},
+		}}, []string{"nome"}},
+		{"SELECT * FROM Order WHERE day = DATETIME('2017-03-01')", &Query{Kind: "Order", Filters: []Filter{
+			{Property: "day", Operator: "=", Value: time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)},
+		}}, nil},
+	}
+	for _, tc := range cases {
+		q, props, err := ParseGQL(c, tc.GQL)
+		if err != nil {
+			t.Errorf("Error parsing %s: %v", tc.GQL, err)
+			continue
+		}
+		if !reflect.DeepEqual(q, tc.Query) {
+			t.Errorf("Unexpected query for %s:\n%#v\nexpected\n%#v", tc.GQL, q, tc.Query)
+		}
+		if !reflect.DeepEqual(props, tc.Properties) {
+			t.Errorf("Unexpected properties for %s: %v, expected %v", tc.GQL, props, tc.Properties)
+		}
+	}
+}
+
+func TestParseGQLErrors(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	cases := []struct {
+		GQL, Error string
+	}{
+		{"", "expected SELECT, found end of query"},
+		{"SELECT * Order", `expected FROM, found "Order" at position 9`},
+		{"SELECT * FROM", "expected kind, found end of query"},
+		{"SELECT * FROM Order WHERE status != 'open'", "the != operator is not supported"},
+		{"SELECT * FROM Order WHERE status IN ('open')", "the IN operator is not supported"},
+		{"SELECT * FROM Order WHERE status = 'open", "unterminated ' quote at position 35"},
+		{"SELECT * FROM Order WHERE created > DATETIME('yesterday')", "invalid DATETIME at position 36"},
+		{"SELECT * FROM Order WHERE __key__ = 'Order,1'", "__key__ must be compared to a KEY"},
+		{"SELECT * FROM Order WHERE __key__ HAS ANCESTOR KEY(Customer)", "invalid KEY"},
+		{"SELECT * FROM Order WHERE __key__ HAS ANCESTOR KEY(Customer, ana)", "names must be quoted"},
+		{"SELECT * FROM Order LIMIT -1", "expected a non-negative integer after LIMIT"},
+		{"SELECT * FROM Order LIMIT", "expected a non-negative integer after LIMIT, found end of query"},
+		{"SELECT * FROM Order WHERE created > DATETIME(", "expected a string, number or name, found end of query"},
+		{"SELECT * FROM Order LIMIT 10 extra", `expected end of query, found "extra"`},
+		{"SELECT * FROM Order WHERE a = 1 OR b = 2", `expected end of query, found "OR"`},
+		{"SELECT * FROM Order WHERE a = @1", `unexpected "@"`},
+		{"SELECT * FROM Order WHERE a = 1 § 2", `unexpected "§" at position 32`},
+	}
+	for _, tc := range cases {
+		_, _, err := ParseGQL(c, tc.GQL)
+		if err == nil {
+			t.Errorf("Expected error parsing %s", tc.GQL)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.Error) {
+			t.Errorf("Unexpected error parsing %s: %v, expected %s", tc.GQL, err, tc.Error)
+		}
+	}
+}
+
+func TestParseGQLRun(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, memoryFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		GQL      string
+		Expected string
+	}{
+		{"SELECT * FROM Order WHERE customer = 'ana' ORDER BY total DESC", "Order,3 Customer,ana,Order,5 Order,1"},
+		{"SELECT __key__ FROM Order WHERE __key__ HAS ANCESTOR KEY(Customer, 'ana')", "Customer,ana,Order,5"},
+		{"SELECT * FROM Order WHERE total > 10 AND total < 40 ORDER BY total LIMIT 2", "Customer,ana,Order,5 Order,2"},
+	}
+	for _, tc := range cases {
+		q, _, err := ParseGQL(c, tc.GQL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := runKeys(c, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(keys, " "); got != tc.Expected {
+			t.Errorf("Unexpected results for %s: %s, expected %s", tc.GQL, got, tc.Expected)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// ParseKey parses s in any of the key forms accepted by the command line
+// tools: a JSON key path, like ["User",123], a key path as returned by
+// KeyPath, like User,123, or an encoded key as returned by Key.Encode.
+func ParseKey(c context.Context, s string) (*datastore.Key, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "["):
+		return ParseJSONKey(c, s)
+	case strings.Contains(s, ","):
+		return ParseKeyPath(c, s)
+	}
+	return datastore.DecodeKey(s)
+}
+
+// ParseKeyPath parses the key path returned by KeyPath, as a comma
+// separated list of kinds and IDs, like "Parent,1,Child,name", back into
+// a key. IDs are integers if they are valid numbers without leading zeros,
+// and string names otherwise. Only complete keys are supported.
+func ParseKeyPath(c context.Context, path string) (*datastore.Key, error) {
+	parts := strings.Split(path, ",")
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("aetools: invalid key path %q: expected kind and id pairs", path)
+	}
+	var k *datastore.Key
+	for i := 0; i < len(parts); i += 2 {
+		kind, id := strings.TrimSpace(parts[i]), strings.TrimSpace(parts[i+1])
+		if kind == "" || id == "" {
+			return nil, fmt.Errorf("aetools: invalid key path %q: empty kind or id", path)
+		}
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil && n > 0 && strconv.FormatInt(n, 10) == id {
+			k = datastore.NewKey(c, kind, "", n, k)
+		} else {
+			k = datastore.NewKey(c, kind, id, 0, k)
+		}
+	}
+	return k, nil
+}
+
+// ParseJSONKey parses a key encoded as a JSON array, like the "__key__"
+// attribute of the JSON format: ["Parent",1,"Child","name"].
+func ParseJSONKey(c context.Context, s string) (*datastore.Key, error) {
+	v, err := parseJSONValue(s)
+	if err != nil {
+		return nil, fmt.Errorf("aetools: invalid JSON key %s: %v", s, err)
+	}
+	return decodeKey(c, v)
+}
+
+// CompareKeys compares k and other, returning -1, 0, 1 if k is less than
+// equal or grather than other, taking into account the full ancestor path.
+// Each path element is compared by AppID, Kind and ID, and keys with
+// integer IDs are smaller than keys with string IDs.
+func CompareKeys(k, other *datastore.Key) int {
+	if k == other {
+		return 0
+	}
+	thisPath := ancestorPath(k)
+	otherPath := ancestorPath(other)
+	for i, thisKey := range thisPath {
+		if i >= len(otherPath) {
+			return 1
+		}
+		if r := cmpKey(thisKey, otherPath[i]); r != 0 {
+			return r
+		}
+	}
+	if len(otherPath) > len(thisPath) {
+		return -1
+	}
+	return 0
+}
+
+// ancestorPath decomposes the ancestor path of k as a slice of keys,
+// where the first ancestor is at position 0.
+func ancestorPath(k *datastore.Key) []*datastore.Key {
+	path := make([]*datastore.Key, 0)
+	for p := k; p != nil; p = p.Parent() {
+		path = append(path, nil)
+		copy(path[1:], path[0:])
+		path[0] = p
+	}
+	return path
+}
+
+// cmpKey compares k and other, returning -1, 0 or 1 if k is
+// less than, equal or grather than other. The algorithm doesn't
+// takes into account any ancestors in the two keys. The order
+// of comparision is AppID, Kind, IntID and StringID. Keys with
+// integer identifiers are smaller than string identifiers.
+func cmpKey(k, other *datastore.Key) int {
+	if k == other {
+		return 0
+	}
+	if r := cmpStr(k.AppID(), other.AppID()); r != 0 {
+		return r
+	}
+	if r := cmpStr(k.Kind(), other.Kind()); r != 0 {
+		return r
+	}
+	if k.IntID() != 0 {
+		if other.IntID() == 0 {
+			return -1
+		}
+		return cmpInt(k.IntID(), other.IntID())
+	}
+	if other.IntID() != 0 {
+		return 1
+	}
+	return cmpStr(k.StringID(), other.StringID())
+}
+
+// cmpStr compares two strings returning -1, 0, or 1 if
+// s is less than, equal or grather than other.
+func cmpStr(s, other string) int {
+	if s < other {
+		return -1
+	} else if s > other {
+		return 1
+	}
+	return 0
+}
+
+// cmpInt compares two int64 returning -1, 0, or 1 if i is
+// less than, equal or grather than other.
+func cmpInt(i, other int64) int {
+	if i < other {
+		return -1
+	} else if i > other {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,362 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// MapJobKind is the kind used by Map to record each job.
+	MapJobKind = "_AetoolsMapJob"
+
+	// MapRangeKind is the kind used by Map to record the progress of each
+	// key range of a job, as children of the job entity.
+	MapRangeKind = "_AetoolsMapRange"
+)
+
+// KeyRange is an interval of keys of a kind, including Start and
+// excluding End. A nil Start or End means the range is open.
+type KeyRange struct {
+	Start *datastore.Key
+	End   *datastore.Key
+}
+
+// query returns a query for the entities of kind in the range,
+// sorted by key.
+func (r KeyRange) query(kind string) *Query {
+	q := &Query{Kind: kind, Orders: []string{"__key__"}}
+	if r.Start != nil {
+		q.Filters = append(q.Filters, Filter{Property: "__key__", Operator: ">=", Value: r.Start})
+	}
+	if r.End != nil {
+		q.Filters = append(q.Filters, Filter{Property: "__key__", Operator: "<", Value: r.End})
+	}
+	return q
+}
+
+// KeyRangesForKind splits the keys of kind in up to n+1 ranges, using the
+// __scatter__ property to pick n keys evenly distributed over the kind.
+// The first range starts at the first key, and the last range is open.
+// No ranges are returned if the kind has no entities.
+func KeyRangesForKind(c context.Context, kind string, n int) ([]KeyRange, error) {
+	return KeyRangesByProperty(c, kind, scatterProperty, n)
+}
+
+// KeyRangesByProperty is like KeyRangesForKind, picking the n keys of the
+// entities with the lowest values of property instead of __scatter__.
+// It is useful with stores and fixtures without scatter values.
+func KeyRangesByProperty(c context.Context, kind, property string, n int) ([]KeyRange, error) {
+	s := StoreFromContext(c)
+	it := s.Run(c, &Query{Kind: kind, Orders: []string{"__key__"}, KeysOnly: true, Limit: 1})
+	start, err := it.Next(nil)
+	if err == datastore.Done {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*datastore.Key
+	q := &Query{Kind: kind, Orders: []string{property}, KeysOnly: true, Limit: n}
+	for it := s.Run(c, q); ; {
+		k, err := it.Next(nil)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	sort.Sort(byKey(keys))
+	ranges := make([]KeyRange, 0, len(keys)+1)
+	for _, k := range keys {
+		if CompareKeys(start, k) >= 0 {
+			continue
+		}
+		ranges = append(ranges, KeyRange{start, k})
+		start = k
+	}
+	return append(ranges, KeyRange{start, nil}), nil
+}
+
+// MapFunc is called by Map with each batch of entities.
+type MapFunc func(c context.Context, batch []*Entity) error
+
+// EachEntity returns a MapFunc that calls f with each entity of the batch.
+func EachEntity(f func(c context.Context, e *Entity) error) MapFunc {
+	return func(c context.Context, batch []*Entity) error {
+		for _, e := range batch {
+			if err := f(c, e); err != nil {
+				return fmt.Errorf("%v: %v", e.Key, err)
+			}
+		}
+		return nil
+	}
+}
+
+// MapOptions configures how Map splits and processes a kind.
+type MapOptions struct {
+	// BatchSize is the number of entities passed to each MapFunc call.
+	BatchSize int
+
+	// Shards is the number of scatter keys used to split the kind.
+	// The kind is processed in up to Shards+1 key ranges.
+	Shards int
+
+	// Workers is the maximum number of key ranges processed concurrently.
+	Workers int
+
+	// Job is the name used to save the progress of each key range.
+	// A Map call with the name of an interrupted job resumes it, skipping
+	// the finished ranges and the batches already processed. If empty,
+	// the progress is not saved.
+	Job string
+}
+
+// MapProgress is the saved state of a key range processed by Map.
+type MapProgress struct {
+	KeyRange
+	// Cursor is the position after the last batch processed.
+	Cursor string
+	// Processed is the number of entities processed.
+	Processed int64
+	// Done indicates that all entities of the range were processed.
+	Done bool
+}
+
+// Map calls fn with all entities of kind, in batches of o.BatchSize.
+// The kind is split in key ranges, processed concurrently by up to o.Workers
+// goroutines. Entities of each range are processed in key order, one batch
+// at a time. Map stops at the first error, after the running batches finish,
+// returning the number of entities processed in this call.
+//
+// If o.Job is set, the key ranges and the cursor after each batch are saved
+// in the MapJobKind and MapRangeKind kinds, so a failed or interrupted job
+// can be resumed by calling Map again with the same name. Entities in a
+// batch that was interrupted are processed again when resuming, so fn
+// should be idempotent.
+func Map(c context.Context, kind string, fn MapFunc, o *MapOptions) (int64, error) {
+	progress, err := mapRanges(c, kind, o)
+	if err != nil {
+		return 0, err
+	}
+	workers := o.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		total  int64
+		failed bool
+		errs   = make([]error, len(progress))
+		work   = make(chan int)
+	)
+	stop := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				n, err := mapRange(c, kind, fn, o, r, progress[r], stop)
+				mu.Lock()
+				total += n
+				if err != nil {
+					errs[r] = err
+					failed = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for r, p := range progress {
+		if p.Done {
+			continue
+		}
+		if stop() {
+			break
+		}
+		work <- r
+	}
+	close(work)
+	wg.Wait()
+	for r, err := range errs {
+		if err != nil {
+			return total, fmt.Errorf("aetools: map range %d of %s: %v", r, kind, err)
+		}
+	}
+	return total, nil
+}
+
+// mapRange processes the entities in the range r, until the range is
+// finished or stop returns true.
+func mapRange(c context.Context, kind string, fn MapFunc, o *MapOptions, r int, p *MapProgress, stop func() bool) (int64, error) {
+	size := o.BatchSize
+	if size <= 0 {
+		size = 50
+	}
+	s := StoreFromContext(c)
+	q := p.query(kind)
+	q.Limit = size
+	q.Start = p.Cursor
+	var count int64
+	for !stop() {
+		batch := make([]*Entity, 0, size)
+		it := s.Run(c, q)
+		for {
+			e := new(Entity)
+			_, err := it.Next(e)
+			if err == datastore.Done {
+				break
+			}
+			if err != nil {
+				return count, err
+			}
+			batch = append(batch, e)
+		}
+		if len(batch) > 0 {
+			if err := fn(c, batch); err != nil {
+				return count, err
+			}
+		}
+		cur, err := it.Cursor()
+		if err != nil {
+			return count, err
+		}
+		count += int64(len(batch))
+		p.Processed += int64(len(batch))
+		p.Cursor, p.Done = cur, len(batch) < size
+		q.Start = cur
+		if err := putMapProgress(c, o.Job, r, p); err != nil {
+			return count, err
+		}
+		if p.Done {
+			log.Infof(c, "map: range %d of %s done, %d entities processed", r, kind, p.Processed)
+			break
+		}
+	}
+	return count, nil
+}
+
+// mapRanges returns the saved progress of each key range of the job,
+// or computes and saves the key ranges of a new job.
+func mapRanges(c context.Context, kind string, o *MapOptions) ([]*MapProgress, error) {
+	s := StoreFromContext(c)
+	if o.Job != "" {
+		job, err := Get(c, mapJobKey(c, o.Job))
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return nil, err
+		}
+		if err == nil {
+			if k := job.GetString("kind"); k != kind {
+				return nil, fmt.Errorf("aetools: map job %s is for kind %s, not %s", o.Job, k, kind)
+			}
+			progress, err := MapJobProgress(c, o.Job)
+			if err != nil {
+				return nil, err
+			}
+			if n := int(job.GetInt("ranges")); len(progress) != n {
+				return nil, fmt.Errorf("aetools: map job %s has %d saved ranges, expected %d", o.Job, len(progress), n)
+			}
+			log.Infof(c, "map: resuming job %s with %d ranges", o.Job, len(progress))
+			return progress, nil
+		}
+	}
+	shards := o.Shards
+	if shards <= 0 {
+		shards = 32
+	}
+	ranges, err := KeyRangesForKind(c, kind, shards)
+	if err != nil {
+		return nil, err
+	}
+	progress := make([]*MapProgress, len(ranges))
+	for i, r := range ranges {
+		progress[i] = &MapProgress{KeyRange: r}
+		if err := putMapProgress(c, o.Job, i, progress[i]); err != nil {
+			return nil, err
+		}
+	}
+	if o.Job != "" {
+		job := &Entity{Key: mapJobKey(c, o.Job)}
+		job.Add(datastore.Property{Name: "kind", Value: kind})
+		job.Add(datastore.Property{Name: "ranges", Value: int64(len(ranges)), NoIndex: true})
+		if _, err := s.PutMulti(c, []*datastore.Key{job.Key}, []Entity{*job}); err != nil {
+			return nil, err
+		}
+	}
+	return progress, nil
+}
+
+// putMapProgress saves the progress of the range r of job, if job is set.
+func putMapProgress(c context.Context, job string, r int, p *MapProgress) error {
+	if job == "" {
+		return nil
+	}
+	e := &Entity{Key: mapRangeKey(c, job, r)}
+	e.Add(datastore.Property{Name: "start", Value: p.Start, NoIndex: true})
+	e.Add(datastore.Property{Name: "end", Value: p.End, NoIndex: true})
+	e.Add(datastore.Property{Name: "cursor", Value: p.Cursor, NoIndex: true})
+	e.Add(datastore.Property{Name: "processed", Value: p.Processed, NoIndex: true})
+	e.Add(datastore.Property{Name: "done", Value: p.Done})
+	_, err := StoreFromContext(c).PutMulti(c, []*datastore.Key{e.Key}, []Entity{*e})
+	return err
+}
+
+// MapJobProgress returns the saved progress of each key range of job.
+func MapJobProgress(c context.Context, job string) ([]*MapProgress, error) {
+	var progress []*MapProgress
+	q := &Query{Kind: MapRangeKind, Ancestor: mapJobKey(c, job), Orders: []string{"__key__"}}
+	for it := StoreFromContext(c).Run(c, q); ; {
+		var e Entity
+		_, err := it.Next(&e)
+		if err == datastore.Done {
+			return progress, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		progress = append(progress, loadMapProgress(&e))
+	}
+}
+
+// loadMapProgress converts a range progress entity to a MapProgress.
+func loadMapProgress(e *Entity) *MapProgress {
+	p := &MapProgress{
+		Cursor:    e.GetString("cursor"),
+		Processed: e.GetInt("processed"),
+		Done:      e.GetBool("done"),
+	}
+	p.Start, _ = e.Get("start").(*datastore.Key)
+	p.End, _ = e.Get("end").(*datastore.Key)
+	return p
+}
+
+// mapJobKey returns the key of the job entity.
+func mapJobKey(c context.Context, job string) *datastore.Key {
+	return datastore.NewKey(c, MapJobKind, job, 0, nil)
+}
+
+// mapRangeKey returns the key of the progress entity of the range r of job.
+func mapRangeKey(c context.Context, job string, r int) *datastore.Key {
+	return datastore.NewKey(c, MapRangeKind, "", int64(r+1), mapJobKey(c, job))
+}
+
+// byKey sorts keys in datastore order.
+type byKey []*datastore.Key
+
+func (b byKey) Len() int           { return len(b) }
+func (b byKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byKey) Less(i, j int) bool { return CompareKeys(b[i], b[j]) < 0 }
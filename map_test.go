@@ -0,0 +1,165 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// newMapContext returns a memory context with n entities of kind Counter.
+func newMapContext(t *testing.T, n int) context.Context {
+	c := NewMemoryContext(context.Background())
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"__key__": ["Counter", %d], "value": %d}`, i, i)
+	}
+	b.WriteString("]")
+	if _, err := Load(c, &b, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestKeyRangesForKind(t *testing.T) {
+	c := newMapContext(t, 200)
+	ranges, err := KeyRangesForKind(c, "Counter", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 5 {
+		t.Fatalf("Unexpected ranges: %d, expected 5", len(ranges))
+	}
+	if ranges[0].Start.IntID() != 1 || ranges[4].End != nil {
+		t.Errorf("Unexpected range limits: %v, %v", ranges[0].Start, ranges[4].End)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if !ranges[i].Start.Equal(ranges[i-1].End) {
+			t.Errorf("Range %d starts at %v, expected %v", i, ranges[i].Start, ranges[i-1].End)
+		}
+	}
+	if ranges, err := KeyRangesForKind(c, "Empty", 4); err != nil || len(ranges) != 0 {
+		t.Errorf("Unexpected ranges for empty kind: %v, %v", ranges, err)
+	}
+}
+
+func TestKeyRangesByProperty(t *testing.T) {
+	c := newMapContext(t, 200)
+	ranges, err := KeyRangesByProperty(c, "Counter", "-value", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]int64{{1, 199}, {199, 200}, {200, 0}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Unexpected ranges: %v, expected %v", ranges, expected)
+	}
+	for i, e := range expected {
+		r := ranges[i]
+		if r.Start.IntID() != e[0] || (r.End == nil) != (e[1] == 0) || r.End != nil && r.End.IntID() != e[1] {
+			t.Errorf("Unexpected range %d: %v, expected %v", i, r, e)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	c := newMapContext(t, 200)
+	var (
+		mu   sync.Mutex
+		seen = make(map[int64]int)
+	)
+	n, err := Map(c, "Counter", EachEntity(func(c context.Context, e *Entity) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[e.GetInt("value")]++
+		return nil
+	}), &MapOptions{BatchSize: 7, Shards: 8, Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 200 || len(seen) != 200 {
+		t.Errorf("Unexpected entities processed: %d, %d distinct, expected 200", n, len(seen))
+	}
+	for v, count := range seen {
+		if count != 1 {
+			t.Errorf("Entity %d processed %d times", v, count)
+		}
+	}
+}
+
+func TestMapResume(t *testing.T) {
+	c := newMapContext(t, 200)
+	var (
+		mu   sync.Mutex
+		seen = make(map[int64]int)
+		fail = true
+	)
+	fn := func(c context.Context, batch []*Entity) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range batch {
+			if fail && e.Key.IntID() == 150 {
+				return errors.New("failure")
+			}
+		}
+		for _, e := range batch {
+			seen[e.Key.IntID()]++
+		}
+		return nil
+	}
+	o := &MapOptions{BatchSize: 5, Shards: 8, Workers: 2, Job: "resume"}
+	first, err := Map(c, "Counter", fn, o)
+	if err == nil {
+		t.Fatal("Expected failure on first run")
+	}
+	progress, err := MapJobProgress(c, "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) < 2 {
+		t.Fatalf("Unexpected saved ranges: %d", len(progress))
+	}
+
+	fail = false
+	second, err := Map(c, "Counter", fn, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first+second != 200 || second >= 200 {
+		t.Errorf("Unexpected entities processed: %d and %d, expected 200 in total", first, second)
+	}
+	if len(seen) != 200 {
+		t.Errorf("Unexpected distinct entities processed: %d, expected 200", len(seen))
+	}
+	for id, count := range seen {
+		if count != 1 {
+			t.Errorf("Entity %d processed %d times", id, count)
+		}
+	}
+	progress, err = MapJobProgress(c, "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range progress {
+		if !p.Done {
+			t.Errorf("Range %d not done after resume: %#v", i, p)
+		}
+	}
+
+	// Finished job does nothing, and other kinds are rejected
+	if n, err := Map(c, "Counter", fn, o); n != 0 || err != nil {
+		t.Errorf("Unexpected result for finished job: %d, %v", n, err)
+	}
+	if _, err := Map(c, "Other", fn, o); err == nil {
+		t.Errorf("Expected error for job with another kind")
+	}
+}
@@ -12,10 +12,11 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"math"
+	"reflect"
 	"regexp"
 	"strconv"
-	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // MarshalJSON implements the json.Marshaller interface by dumping
@@ -59,6 +60,13 @@ func (e *Entity) Map() (map[string]interface{}, error) {
 			}
 		}
 
+		if v, err := encodeCustom(p); err != nil {
+			return nil, err
+		} else if v != nil {
+			add(p.Multiple, p.Name, v)
+			continue
+		}
+
 		switch p.Value.(type) {
 		case int, int32, int64:
 			if p.NoIndex {
@@ -67,12 +75,16 @@ func (e *Entity) Map() (map[string]interface{}, error) {
 				add(p.Multiple, p.Name, p.Value)
 			}
 		case float32, float64:
+			f := reflect.ValueOf(p.Value).Float()
 			if p.NoIndex {
-				add(p.Multiple, p.Name, toMap("float", p.NoIndex, float(p.Value.(float64))))
+				add(p.Multiple, p.Name, toMap("float", p.NoIndex, float(f)))
 			} else {
-				add(p.Multiple, p.Name, float(p.Value.(float64)))
+				add(p.Multiple, p.Name, float(f))
 			}
 		case string:
+			if !utf8.ValidString(p.Value.(string)) {
+				return nil, fmt.Errorf("aetools: invalid UTF-8 string value %s", p.Name)
+			}
 			if p.NoIndex {
 				add(p.Multiple, p.Name, toMap("string", p.NoIndex, p.Value))
 			} else {
@@ -91,7 +103,7 @@ func (e *Entity) Map() (map[string]interface{}, error) {
 			v := toMap("blobkey", p.NoIndex, string(p.Value.(appengine.BlobKey)))
 			add(p.Multiple, p.Name, v)
 		case time.Time:
-			s := p.Value.(time.Time).Format(DateTimeFormat)
+			s := p.Value.(time.Time).UTC().Format(DateTimeFormat)
 			v := toMap("date", p.NoIndex, s)
 			add(p.Multiple, p.Name, v)
 		case []byte:
@@ -146,16 +158,10 @@ func (f float) MarshalJSON() ([]byte, error) {
 }
 
 func decodeJSONPrimitiveValue(v interface{}, p *datastore.Property) error {
+	var err error
 	switch v.(type) {
 	case json.Number:
-		n := v.(json.Number)
-		if strings.Contains(n.String(), ".") {
-			// float64
-			p.Value, _ = n.Float64()
-		} else {
-			// int64
-			p.Value, _ = n.Int64()
-		}
+		p.Value, err = decodeNumber(v.(json.Number))
 	case string:
 		p.Value = v.(string)
 	case bool:
@@ -165,7 +171,37 @@ func decodeJSONPrimitiveValue(v interface{}, p *datastore.Property) error {
 	default:
 		return fmt.Errorf("Invalid primitive value: %#v", v)
 	}
-	return nil
+	return err
+}
+
+// decodeNumber decodes n as a float64 if it has a decimal point or an
+// exponent, and as an int64 otherwise. An error is returned if the value
+// is out of range, instead of silently changing it.
+func decodeNumber(n json.Number) (interface{}, error) {
+	if hasDecimalPoint.MatchString(n.String()) {
+		return decodeFloat(n)
+	}
+	return decodeInt(n)
+}
+
+// decodeInt decodes n as an int64, returning an error if n
+// is not an integer or is out of range.
+func decodeInt(n json.Number) (int64, error) {
+	i, err := strconv.ParseInt(n.String(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("aetools: invalid int value %s: %s", n, err.Error())
+	}
+	return i, nil
+}
+
+// decodeFloat decodes n as a float64, returning an error if n
+// is out of range.
+func decodeFloat(n json.Number) (float64, error) {
+	f, err := strconv.ParseFloat(n.String(), 64)
+	if err != nil {
+		return 0, fmt.Errorf("aetools: invalid float value %s: %s", n, err.Error())
+	}
+	return f, nil
 }
 
 func encodeKey(k *datastore.Key) []interface{} {
@@ -199,12 +235,15 @@ func encodeKey(k *datastore.Key) []interface{} {
 func decodeKey(c context.Context, v interface{}) (*datastore.Key, error) {
 	var result, ancestor *datastore.Key
 	p, ok := v.([]interface{})
-	if !ok {
+	if !ok || len(p) == 0 || len(p)%2 != 0 {
 		return nil, ErrInvalidKeyElement
 	}
 
 	for i := 0; i < len(p); i += 2 {
-		kind := p[i].(string)
+		kind, ok := p[i].(string)
+		if !ok || kind == "" {
+			return nil, ErrInvalidKeyElement
+		}
 		id := p[i+1]
 		switch id.(type) {
 		case string:
@@ -260,6 +299,18 @@ func decodeProperty(c context.Context, k string, v interface{}, e *Entity) error
 		}
 
 		switch t {
+		case "int":
+			n, ok := m["value"].(json.Number)
+			if !ok {
+				return newDecodePropertyError(k, "int", m["value"])
+			}
+			p.Value, err = decodeInt(n)
+		case "float":
+			n, ok := m["value"].(json.Number)
+			if !ok {
+				return newDecodePropertyError(k, "float", m["value"])
+			}
+			p.Value, err = decodeFloat(n)
 		case "key":
 			key, err := decodeKey(c, m["value"])
 			if err != nil {
@@ -293,6 +344,12 @@ func decodeProperty(c context.Context, k string, v interface{}, e *Entity) error
 			}
 			p.Value = dt.UTC()
 		default:
+			if name, ok := t.(string); ok {
+				var handled bool
+				if p.Value, handled, err = decodeCustom(c, k, name, m["value"]); handled {
+					break
+				}
+			}
 			if v, ok := m["value"]; ok {
 				err = decodeJSONPrimitiveValue(v, &p)
 			} else {
@@ -4,16 +4,12 @@
 package aetools
 
 import (
-	"google.golang.org/appengine/aetest"
 	"google.golang.org/appengine/datastore"
 	"testing"
 )
 
 func TestKeyPath(t *testing.T) {
-	c, clean, err := aetest.NewContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	c, clean := newTestContext(t)
 	defer clean()
 
 	keys := []*datastore.Key{
@@ -47,3 +43,36 @@ func TestMarshalFloat(t *testing.T) {
 		}
 	}
 }
+
+func TestParseKey(t *testing.T) {
+	c := offline
+	parent := datastore.NewKey(c, "User", "", 123, nil)
+	child := datastore.NewKey(c, "Order", "007", 0, parent)
+	cases := []struct {
+		in       string
+		expected *datastore.Key
+	}{
+		{"User,123", parent},
+		{" User, 123 ", parent},
+		{`["User",123]`, parent},
+		{"User,123,Order,007", child},
+		{`["User",123,"Order","007"]`, child},
+		{KeyPath(child), child},
+		{"User,abc", datastore.NewKey(c, "User", "abc", 0, nil)},
+	}
+	for _, tc := range cases {
+		k, err := ParseKey(c, tc.in)
+		if err != nil {
+			t.Errorf("ParseKey(%q): unexpected error %v", tc.in, err)
+			continue
+		}
+		if !k.Equal(tc.expected) {
+			t.Errorf("ParseKey(%q) = %v, expected %v", tc.in, k, tc.expected)
+		}
+	}
+	for _, in := range []string{"User,1,Order", ",1", "User,", `["User"]`, `[1,2]`, `["User",1.5]`, `["User",1`, "not-a-key"} {
+		if k, err := ParseKey(c, in); err == nil {
+			t.Errorf("ParseKey(%q) = %v, expected error", in, k)
+		}
+	}
+}
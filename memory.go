@@ -0,0 +1,762 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// scatterProperty is the special property used to sort entities
+	// in a pseudo-random order, used to split a kind in key ranges.
+	scatterProperty = "__scatter__"
+
+	// memoryScatterRate is the average number of entities for
+	// each entity with a scatter value in a MemoryStore.
+	memoryScatterRate = 16
+)
+
+var (
+	// ErrInvalidCursor is returned when a query cursor can't be decoded.
+	ErrInvalidCursor = errors.New("aetools: invalid cursor")
+
+	// ErrInvalidQuery is returned when a query uses an unsupported
+	// filter operator or order.
+	ErrInvalidQuery = errors.New("aetools: invalid query")
+)
+
+// MemoryStore is a Store that keeps the entities in memory,
+// allowing the aetools functions to run without the App Engine SDK:
+//
+//	c := aetools.NewMemoryContext(context.Background())
+//	_, err := aetools.Load(c, fixture, aetools.LoadSync)
+//
+// Queries are executed with the datastore semantics: results are sorted
+// by the given orders and then by key, filters and orders only see
+// the indexed property values, and entities without a value for a
+// filtered or ordered property are not returned.
+//
+// A MemoryStore also provides the __scatter__ property, set in about one
+// of every 16 entities, and the __Stat_Kind__ and
+// __Stat_PropertyType_PropertyName_Kind__ statistics, computed from the
+// current data unless entities for those kinds were explicitly saved.
+type MemoryStore struct {
+	mu       sync.Mutex
+	entities map[string]*Entity
+	lastID   int64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entities: make(map[string]*Entity)}
+}
+
+// NewMemoryContext returns a copy of parent, usable outside App Engine,
+// that makes the aetools functions use a new, empty MemoryStore.
+// See OfflineContext for details about the returned context.
+func NewMemoryContext(parent context.Context) context.Context {
+	return WithStore(OfflineContext(parent), NewMemoryStore())
+}
+
+// Len returns the number of entities saved in s.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entities)
+}
+
+func (s *MemoryStore) GetMulti(c context.Context, keys []*datastore.Key, dst []Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var (
+		me     = make(appengine.MultiError, len(keys))
+		failed = false
+		stats  map[string]*Entity
+	)
+	for i, k := range keys {
+		if k == nil || k.Incomplete() {
+			me[i], failed = datastore.ErrInvalidKey, true
+			continue
+		}
+		e, ok := s.entities[k.Encode()]
+		if !ok && isStatKind(k.Kind()) && !s.hasKind(k.Kind()) {
+			if stats == nil {
+				stats = make(map[string]*Entity)
+				for _, st := range s.stats(c, k.Kind()) {
+					stats[st.Key.Encode()] = st
+				}
+			}
+			e, ok = stats[k.Encode()]
+		}
+		if !ok {
+			me[i], failed = datastore.ErrNoSuchEntity, true
+			continue
+		}
+		dst[i].Key = k
+		dst[i].Properties = copyProperties(e.Properties)
+	}
+	if failed {
+		return me
+	}
+	return nil
+}
+
+func (s *MemoryStore) PutMulti(c context.Context, keys []*datastore.Key, src []Entity) ([]*datastore.Key, error) {
+	if len(keys) != len(src) {
+		return nil, errors.New("aetools: keys and src slices have different length")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Validate all entities first, so nothing is saved on errors
+	for i, k := range keys {
+		if k == nil {
+			return nil, datastore.ErrInvalidKey
+		}
+		for _, p := range src[i].Properties {
+			if err := checkValue(p.Value); err != nil {
+				return nil, fmt.Errorf("aetools: property %s: %v", p.Name, err)
+			}
+		}
+	}
+	result := make([]*datastore.Key, len(keys))
+	for i, k := range keys {
+		if k.Incomplete() {
+			s.lastID++
+			k = datastore.NewKey(keyContext(c, k), k.Kind(), "", s.lastID, k.Parent())
+		} else if k.IntID() > s.lastID {
+			// Never allocate IDs already in use
+			s.lastID = k.IntID()
+		}
+		s.entities[k.Encode()] = &Entity{Key: k, Properties: copyProperties(src[i].Properties)}
+		result[i] = k
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) DeleteMulti(c context.Context, keys []*datastore.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		if k == nil || k.Incomplete() {
+			return datastore.ErrInvalidKey
+		}
+		delete(s.entities, k.Encode())
+	}
+	return nil
+}
+
+func (s *MemoryStore) AllocateIDs(c context.Context, kind string, parent *datastore.Key, n int) ([]*datastore.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		s.lastID++
+		keys[i] = datastore.NewKey(keyContext(c, parent), kind, "", s.lastID, parent)
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) Run(c context.Context, q *Query) Iterator {
+	orders, err := memoryOrders(q.Orders)
+	if err != nil {
+		return &errIterator{err}
+	}
+	for _, f := range q.Filters {
+		if !validOperator(f.Operator) {
+			return &errIterator{fmt.Errorf("%v: unsupported operator %q", ErrInvalidQuery, f.Operator)}
+		}
+	}
+
+	s.mu.Lock()
+	var candidates []*Entity
+	if isStatKind(q.Kind) && !s.hasKind(q.Kind) {
+		candidates = s.stats(c, q.Kind)
+	} else {
+		for _, e := range s.entities {
+			if q.Kind == "" || e.Key.Kind() == q.Kind {
+				candidates = append(candidates, e)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	results := make([]*memoryResult, 0, len(candidates))
+	for _, e := range candidates {
+		if q.Ancestor != nil && !hasAncestor(e.Key, q.Ancestor) {
+			continue
+		}
+		if !matchFilters(e, q.Filters) {
+			continue
+		}
+		r := &memoryResult{key: e.Key, props: e.Properties}
+		if r.values = sortValues(e, orders); r.values == nil {
+			continue
+		}
+		results = append(results, r)
+	}
+	sort.Sort(byOrder{results, orders})
+
+	if q.Start != "" {
+		start, err := decodeMemoryCursor(c, q.Start, len(orders))
+		if err != nil {
+			return &errIterator{err}
+		}
+		i := sort.Search(len(results), func(i int) bool {
+			return compareResults(results[i], start, orders) > 0
+		})
+		results = results[i:]
+	}
+	if q.End != "" {
+		end, err := decodeMemoryCursor(c, q.End, len(orders))
+		if err != nil {
+			return &errIterator{err}
+		}
+		i := sort.Search(len(results), func(i int) bool {
+			return compareResults(results[i], end, orders) > 0
+		})
+		results = results[:i]
+	}
+	if q.Offset > 0 {
+		if q.Offset > len(results) {
+			results = nil
+		} else {
+			results = results[q.Offset:]
+		}
+	}
+	if q.Limit > 0 && q.Limit < len(results) {
+		results = results[:q.Limit]
+	}
+	return &memoryIterator{c: c, start: q.Start, results: results, keysOnly: q.KeysOnly}
+}
+
+// hasKind reports if there are saved entities of the given kind.
+// The caller must hold s.mu.
+func (s *MemoryStore) hasKind(kind string) bool {
+	for _, e := range s.entities {
+		if e.Key.Kind() == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryOrder is a parsed query order.
+type memoryOrder struct {
+	property string
+	desc     bool
+}
+
+// memoryOrders parses the query orders, appending the key order
+// used to break ties.
+func memoryOrders(orders []string) ([]memoryOrder, error) {
+	r := make([]memoryOrder, 0, len(orders)+1)
+	for _, o := range orders {
+		o = strings.TrimSpace(o)
+		desc := strings.HasPrefix(o, "-")
+		o = strings.TrimPrefix(o, "-")
+		if o == "" {
+			return nil, fmt.Errorf("%v: empty order", ErrInvalidQuery)
+		}
+		r = append(r, memoryOrder{property: o, desc: desc})
+		if o == "__key__" {
+			return r, nil
+		}
+	}
+	return append(r, memoryOrder{property: "__key__"}), nil
+}
+
+// memoryResult is a query result with the values used to sort it.
+type memoryResult struct {
+	key    *datastore.Key
+	props  datastore.PropertyList
+	values []interface{}
+}
+
+// byOrder sorts the query results by the query orders.
+type byOrder struct {
+	results []*memoryResult
+	orders  []memoryOrder
+}
+
+func (b byOrder) Len() int      { return len(b.results) }
+func (b byOrder) Swap(i, j int) { b.results[i], b.results[j] = b.results[j], b.results[i] }
+func (b byOrder) Less(i, j int) bool {
+	return compareResults(b.results[i], b.results[j], b.orders) < 0
+}
+
+// compareResults compares the sort values of a and b using orders.
+func compareResults(a, b *memoryResult, orders []memoryOrder) int {
+	for i, o := range orders {
+		r := compareValues(a.values[i], b.values[i])
+		if o.desc {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+// sortValues returns the values of e used to sort it with orders, or
+// nil if e has no indexed value for one of the ordered properties.
+func sortValues(e *Entity, orders []memoryOrder) []interface{} {
+	values := make([]interface{}, len(orders))
+	for i, o := range orders {
+		indexed := indexedValues(e, o.property)
+		if len(indexed) == 0 {
+			return nil
+		}
+		v := indexed[0]
+		for _, other := range indexed[1:] {
+			r := compareValues(other, v)
+			if (o.desc && r > 0) || (!o.desc && r < 0) {
+				v = other
+			}
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// indexedValues returns the indexed values of the named property,
+// handling the special __key__ and __scatter__ properties.
+func indexedValues(e *Entity, name string) []interface{} {
+	switch name {
+	case "__key__":
+		return []interface{}{e.Key}
+	case scatterProperty:
+		if v, ok := scatterValue(e.Key); ok {
+			return []interface{}{v}
+		}
+		return nil
+	}
+	var values []interface{}
+	for _, p := range e.Properties {
+		if p.Name == name && !p.NoIndex {
+			values = append(values, normalizeValue(p.Value))
+		}
+	}
+	return values
+}
+
+// scatterValue returns the pseudo-random scatter value for k,
+// if k is one of the keys with a scatter value.
+func scatterValue(k *datastore.Key) (int64, bool) {
+	h := fnv.New64a()
+	h.Write([]byte(k.String()))
+	v := int64(h.Sum64() >> 1)
+	return v, v%memoryScatterRate == 0
+}
+
+// hasAncestor reports if ancestor is in the path of k, including k itself.
+func hasAncestor(k, ancestor *datastore.Key) bool {
+	for p := k; p != nil; p = p.Parent() {
+		if p.Equal(ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// validOperator reports if op is a supported filter operator.
+func validOperator(op string) bool {
+	switch op {
+	case "=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// matchFilters reports if e matches all filters. Multiple valued
+// properties match a filter if any of its values matches it.
+func matchFilters(e *Entity, filters []Filter) bool {
+	for _, f := range filters {
+		matched := false
+		for _, v := range indexedValues(e, f.Property) {
+			if matchOperator(compareValues(v, normalizeValue(f.Value)), f.Operator) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchOperator reports if the comparison result r satisfies op.
+func matchOperator(r int, op string) bool {
+	switch op {
+	case "=":
+		return r == 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	}
+	return false
+}
+
+// normalizeValue converts v to the type used by the datastore to save it.
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case appengine.BlobKey:
+		return string(v)
+	case datastore.ByteString:
+		return string(v)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+// checkValue returns an error if v is not a valid property value.
+func checkValue(v interface{}) error {
+	switch v.(type) {
+	case nil, int, int8, int16, int32, int64, float32, float64, bool, string,
+		[]byte, datastore.ByteString, time.Time, *datastore.Key,
+		appengine.BlobKey, appengine.GeoPoint, *datastore.Entity:
+		return nil
+	}
+	return fmt.Errorf("invalid value type %T", v)
+}
+
+// valueRank returns the position of the type of v in the datastore
+// sort order, used to compare values of different types.
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64:
+		return 1
+	case time.Time:
+		return 2
+	case bool:
+		return 3
+	case string:
+		return 4
+	case float64:
+		return 5
+	case appengine.GeoPoint:
+		return 6
+	case *datastore.Key:
+		return 7
+	}
+	return 8
+}
+
+// compareValues compares two normalized values, returning -1, 0 or 1
+// if a is less than, equal or grather than b.
+func compareValues(a, b interface{}) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		return cmpInt(int64(ra), int64(rb))
+	}
+	switch a := a.(type) {
+	case int64:
+		return cmpInt(a, b.(int64))
+	case time.Time:
+		t := b.(time.Time)
+		if a.Before(t) {
+			return -1
+		} else if a.After(t) {
+			return 1
+		}
+		return 0
+	case bool:
+		if a == b.(bool) {
+			return 0
+		} else if a {
+			return 1
+		}
+		return -1
+	case string:
+		return cmpStr(a, b.(string))
+	case float64:
+		f := b.(float64)
+		if a < f {
+			return -1
+		} else if a > f {
+			return 1
+		}
+		return 0
+	case appengine.GeoPoint:
+		g := b.(appengine.GeoPoint)
+		if a.Lat != g.Lat {
+			if a.Lat < g.Lat {
+				return -1
+			}
+			return 1
+		}
+		if a.Lng < g.Lng {
+			return -1
+		} else if a.Lng > g.Lng {
+			return 1
+		}
+		return 0
+	case *datastore.Key:
+		return CompareKeys(a, b.(*datastore.Key))
+	}
+	return 0
+}
+
+// copyProperties returns a copy of props, so changes in the
+// copy are not visible in the store.
+func copyProperties(props datastore.PropertyList) datastore.PropertyList {
+	r := make(datastore.PropertyList, len(props))
+	copy(r, props)
+	for i, p := range r {
+		if b, ok := p.Value.([]byte); ok {
+			r[i].Value = append([]byte(nil), b...)
+		}
+	}
+	return r
+}
+
+// keyContext returns a context to build keys in the same namespace of k.
+func keyContext(c context.Context, k *datastore.Key) context.Context {
+	if k == nil || k.Namespace() == "" {
+		return c
+	}
+	if nc, err := appengine.Namespace(c, k.Namespace()); err == nil {
+		return nc
+	}
+	return c
+}
+
+// memoryIterator iterates over the results of a MemoryStore query.
+type memoryIterator struct {
+	c        context.Context
+	start    string
+	results  []*memoryResult
+	last     *memoryResult
+	keysOnly bool
+}
+
+func (i *memoryIterator) Next(e *Entity) (*datastore.Key, error) {
+	if len(i.results) == 0 {
+		return nil, datastore.Done
+	}
+	r := i.results[0]
+	i.results = i.results[1:]
+	i.last = r
+	if e != nil {
+		e.Key = r.key
+		e.Properties = nil
+		if !i.keysOnly {
+			e.Properties = copyProperties(r.props)
+		}
+	}
+	return r.key, nil
+}
+
+func (i *memoryIterator) Cursor() (string, error) {
+	if i.last == nil {
+		return i.start, nil
+	}
+	return encodeMemoryCursor(i.last)
+}
+
+// encodeMemoryCursor encodes the sort values of r as a cursor.
+// The cursor is the base64 encoded JSON of an entity, with the
+// key of r and one property for each sort value.
+func encodeMemoryCursor(r *memoryResult) (string, error) {
+	e := &Entity{Key: r.key}
+	for i, v := range r.values {
+		if i == len(r.values)-1 {
+			// The last value is the key itself
+			break
+		}
+		e.Add(datastore.Property{Name: strconv.Itoa(i), Value: v})
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// decodeMemoryCursor decodes a cursor with n sort values.
+func decodeMemoryCursor(c context.Context, cursor string, n int) (*memoryResult, error) {
+	b, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	a, err := parseJSONArray(bytes.NewReader(append(append([]byte("["), b...), ']')))
+	if err != nil || len(a) != 1 {
+		return nil, ErrInvalidCursor
+	}
+	m, ok := a[0].(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	e, err := decodeEntity(c, m)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	r := &memoryResult{key: e.Key, values: make([]interface{}, n)}
+	for i := 0; i < n-1; i++ {
+		r.values[i] = normalizeValue(e.Get(strconv.Itoa(i)))
+	}
+	r.values[n-1] = e.Key
+	return r, nil
+}
+
+// Statistic kinds computed by a MemoryStore.
+const (
+	statKindKind         = "__Stat_Kind__"
+	statPropertyTypeKind = "__Stat_PropertyType_PropertyName_Kind__"
+)
+
+// isStatKind reports if kind is one of the statistic kinds
+// computed by a MemoryStore.
+func isStatKind(kind string) bool {
+	return kind == statKindKind || kind == statPropertyTypeKind
+}
+
+// stats computes the statistic entities of the given kind
+// from the current data. The caller must hold s.mu.
+func (s *MemoryStore) stats(c context.Context, kind string) []*Entity {
+	type stat struct {
+		count, bytes, indexCount, indexBytes int64
+		kind, name, typ                      string
+	}
+	var (
+		byKind = make(map[string]*stat)
+		byProp = make(map[string]*stat)
+	)
+	for _, e := range s.entities {
+		k := e.Key.Kind()
+		if strings.HasPrefix(k, "__") {
+			continue
+		}
+		ks := byKind[k]
+		if ks == nil {
+			ks = &stat{kind: k}
+			byKind[k] = ks
+		}
+		ks.count++
+		ks.bytes += int64(len(e.Key.String()))
+		for _, p := range e.Properties {
+			size := PropertySize(p)
+			typ := propertyTypeName(p)
+			id := typ + "_" + p.Name + "_" + k
+			ps := byProp[id]
+			if ps == nil {
+				ps = &stat{kind: k, name: p.Name, typ: typ}
+				byProp[id] = ps
+			}
+			ps.count++
+			ps.bytes += size
+			ks.bytes += size
+			if !p.NoIndex {
+				ps.indexCount += 2
+				ps.indexBytes += 2 * size
+				ks.indexCount += 2
+				ks.indexBytes += 2 * size
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	var r []*Entity
+	if kind == statKindKind {
+		for k, st := range byKind {
+			e := &Entity{Key: datastore.NewKey(c, statKindKind, k, 0, nil)}
+			e.Add(datastore.Property{Name: "kind_name", Value: k})
+			e.Add(datastore.Property{Name: "count", Value: st.count})
+			e.Add(datastore.Property{Name: "bytes", Value: st.bytes + st.indexBytes})
+			e.Add(datastore.Property{Name: "entity_bytes", Value: st.bytes})
+			e.Add(datastore.Property{Name: "builtin_index_bytes", Value: st.indexBytes})
+			e.Add(datastore.Property{Name: "builtin_index_count", Value: st.indexCount})
+			e.Add(datastore.Property{Name: "composite_index_bytes", Value: int64(0)})
+			e.Add(datastore.Property{Name: "composite_index_count", Value: int64(0)})
+			e.Add(datastore.Property{Name: "timestamp", Value: now})
+			r = append(r, e)
+		}
+		return r
+	}
+	for id, st := range byProp {
+		e := &Entity{Key: datastore.NewKey(c, statPropertyTypeKind, id, 0, nil)}
+		e.Add(datastore.Property{Name: "kind_name", Value: st.kind})
+		e.Add(datastore.Property{Name: "property_name", Value: st.name})
+		e.Add(datastore.Property{Name: "property_type", Value: st.typ})
+		e.Add(datastore.Property{Name: "count", Value: st.count})
+		e.Add(datastore.Property{Name: "bytes", Value: st.bytes + st.indexBytes})
+		e.Add(datastore.Property{Name: "entity_bytes", Value: st.bytes})
+		e.Add(datastore.Property{Name: "builtin_index_bytes", Value: st.indexBytes})
+		e.Add(datastore.Property{Name: "builtin_index_count", Value: st.indexCount})
+		e.Add(datastore.Property{Name: "timestamp", Value: now})
+		r = append(r, e)
+	}
+	return r
+}
+
+// propertyTypeName returns the datastore statistics type name of p.
+func propertyTypeName(p datastore.Property) string {
+	switch v := p.Value.(type) {
+	case nil:
+		return "NULL"
+	case int, int8, int16, int32, int64:
+		return "Integer"
+	case float32, float64:
+		return "Float"
+	case bool:
+		return "Boolean"
+	case string:
+		if p.NoIndex {
+			return "Text"
+		}
+		return "String"
+	case []byte:
+		if p.NoIndex {
+			return "Blob"
+		}
+		return "ShortBlob"
+	case datastore.ByteString:
+		return "ShortBlob"
+	case time.Time:
+		return "Date/Time"
+	case *datastore.Key:
+		return "Key"
+	case appengine.BlobKey:
+		return "BlobKey"
+	case appengine.GeoPoint:
+		return "GeoPt"
+	case *datastore.Entity:
+		return "EmbeddedEntity"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+var memoryFixture = `[
+	{"__key__": ["Order", 1], "customer": "ana", "total": 10, "tags": ["a", "b"]},
+	{"__key__": ["Order", 2], "customer": "bob", "total": 30, "tags": ["b"]},
+	{"__key__": ["Order", 3], "customer": "ana", "total": 20.5},
+	{"__key__": ["Order", "x"], "customer": "carl", "total": 5},
+	{"__key__": ["Order", 4], "customer": {"type": "string", "indexed": false, "value": "dan"}, "total": 40},
+	{"__key__": ["Customer", "ana", "Order", 5], "customer": "ana", "total": 15},
+	{"__key__": ["Customer", "ana"], "name": "Ana"}
+]`
+
+// runKeys runs q and returns the KeyPath of the results.
+func runKeys(c context.Context, q *Query) ([]string, error) {
+	var keys []string
+	it := StoreFromContext(c).Run(c, q)
+	for {
+		k, err := it.Next(nil)
+		if err == datastore.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, KeyPath(k))
+	}
+}
+
+func TestMemoryStoreQueries(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, memoryFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	ancestor := datastore.NewKey(c, "Customer", "ana", 0, nil)
+	cases := []struct {
+		Query    *Query
+		Expected string
+	}{
+		{&Query{Kind: "Order"}, "Customer,ana,Order,5 Order,1 Order,2 Order,3 Order,4 Order,x"},
+		{&Query{Kind: "Order", Orders: []string{"-__key__"}}, "Order,x Order,4 Order,3 Order,2 Order,1 Customer,ana,Order,5"},
+		{&Query{Kind: "Order", Filters: []Filter{{Property: "customer", Operator: "=", Value: "ana"}}}, "Customer,ana,Order,5 Order,1 Order,3"},
+		{&Query{Kind: "Order", Filters: []Filter{{Property: "customer", Operator: "=", Value: "dan"}}}, ""},
+		// Float values are always grather than integer values
+		{&Query{Kind: "Order", Filters: []Filter{{Property: "total", Operator: ">=", Value: 20}}}, "Order,2 Order,3 Order,4"},
+		{&Query{Kind: "Order", Filters: []Filter{{Property: "tags", Operator: "=", Value: "b"}}}, "Order,1 Order,2"},
+		{&Query{Kind: "Order", Orders: []string{"-total"}, Limit: 3}, "Order,3 Order,4 Order,2"},
+		{&Query{Kind: "Order", Orders: []string{"customer", "-__key__"}}, "Order,3 Order,1 Customer,ana,Order,5 Order,2 Order,x"},
+		{&Query{Kind: "Order", Orders: []string{"tags"}}, "Order,1 Order,2"},
+		{&Query{Kind: "Order", Ancestor: ancestor}, "Customer,ana,Order,5"},
+		{&Query{Kind: "", Ancestor: ancestor}, "Customer,ana Customer,ana,Order,5"},
+		{&Query{Kind: "Order", Offset: 4}, "Order,4 Order,x"},
+	}
+	for _, tc := range cases {
+		keys, err := runKeys(c, tc.Query)
+		if err != nil {
+			t.Errorf("Unexpected error running %#v: %v", tc.Query, err)
+			continue
+		}
+		if got := strings.Join(keys, " "); got != tc.Expected {
+			t.Errorf("Unexpected results for %#v: %q, expected %q", tc.Query, got, tc.Expected)
+		}
+	}
+
+	q := &Query{Kind: "Order", Filters: []Filter{{Property: "total", Operator: "!=", Value: 1}}}
+	if _, err := runKeys(c, q); err == nil {
+		t.Errorf("Expected error with an invalid operator")
+	}
+}
+
+func TestMemoryStoreCursors(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, memoryFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	s := StoreFromContext(c)
+	q := &Query{Kind: "Order", Orders: []string{"-total"}, Limit: 2}
+	var got []string
+	for page := 0; page < 10; page++ {
+		it := s.Run(c, q)
+		n := 0
+		for {
+			k, err := it.Next(nil)
+			if err == datastore.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, KeyPath(k))
+			n++
+		}
+		if n < q.Limit {
+			break
+		}
+		cur, err := it.Cursor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Changes after the cursor position must be visible
+		if page == 0 {
+			e := &Entity{Key: datastore.NewKey(c, "Order", "", 6, nil)}
+			e.Add(datastore.Property{Name: "total", Value: int64(25)})
+			if _, err := s.PutMulti(c, []*datastore.Key{e.Key}, []Entity{*e}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		q.Start = cur
+	}
+	expected := "Order,3 Order,4 Order,2 Order,6 Customer,ana,Order,5 Order,1 Order,x"
+	if s := strings.Join(got, " "); s != expected {
+		t.Errorf("Unexpected paginated results: %q, expected %q", s, expected)
+	}
+
+	q = &Query{Kind: "Order", Start: "invalid!"}
+	if _, err := runKeys(c, q); err == nil {
+		t.Errorf("Expected error with an invalid cursor")
+	}
+}
+
+func TestMemoryStoreScatter(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	s := StoreFromContext(c)
+	keys := make([]*datastore.Key, 1000)
+	entities := make([]Entity, len(keys))
+	for i := range keys {
+		keys[i] = datastore.NewKey(c, "Scatter", "", int64(i+1), nil)
+	}
+	if _, err := s.PutMulti(c, keys, entities); err != nil {
+		t.Fatal(err)
+	}
+	scattered, err := runKeys(c, &Query{Kind: "Scatter", Orders: []string{"__scatter__"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scattered) == 0 || len(scattered) > 200 {
+		t.Errorf("Unexpected number of scatter keys: %d", len(scattered))
+	}
+}
+
+func TestMemoryStoreStats(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, memoryFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	k := datastore.NewKey(c, "__Stat_Kind__", "Order", 0, nil)
+	e, err := Get(c, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := e.GetInt("count"); count != 6 {
+		t.Errorf("Unexpected Order count: %d, expected 6", count)
+	}
+
+	q := &Query{
+		Kind: "__Stat_PropertyType_PropertyName_Kind__",
+		Filters: []Filter{
+			{Property: "kind_name", Operator: "=", Value: "Order"},
+			{Property: "property_name", Operator: "=", Value: "total"},
+		},
+	}
+	keys, err := runKeys(c, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "__Stat_PropertyType_PropertyName_Kind__,Float_total_Order " +
+		"__Stat_PropertyType_PropertyName_Kind__,Integer_total_Order"
+	if s := strings.Join(keys, " "); s != expected {
+		t.Errorf("Unexpected property stats: %q, expected %q", s, expected)
+	}
+}
+
+func TestMemoryStoreDumpAndLoad(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := createSampleEntities(c, 250); err != nil {
+		t.Fatal(err)
+	}
+	w := new(bytes.Buffer)
+	if err := Dump(c, w, &Options{Kind: "User", BatchSize: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(w.String(), "__key__"); n != 250 {
+		t.Errorf("Unexpected dumped entities: %d, expected 250", n)
+	}
+
+	other := NewMemoryContext(context.Background())
+	if _, err := Load(other, w, &Options{BatchSize: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if n := StoreFromContext(other).(*MemoryStore).Len(); n != 250 {
+		t.Errorf("Unexpected loaded entities: %d, expected 250", n)
+	}
+	for _, id := range []int64{1, 100, 250} {
+		k := datastore.NewKey(c, "User", "", id, nil)
+		a, err := Get(c, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Get(other, k)
+		if err != nil {
+			t.Fatal(fmt.Errorf("entity %v not loaded: %v", k, err))
+		}
+		if err := sameEntity(a, b); err != nil {
+			t.Errorf("Loaded entity mismatch: %v", err)
+		}
+	}
+}
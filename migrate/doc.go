@@ -0,0 +1,58 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package migrate runs versioned data migrations over datastore entities.
+
+A migration is a function applied to each entity of a kind, or to the
+results of a query, registered with a unique number:
+
+	func init() {
+		migrate.Register(&migrate.Migration{
+			ID:   1,
+			Name: "Split user name",
+			Kind: "User",
+			Func: func(c context.Context, e *aetools.Entity) (*aetools.Entity, error) {
+				// Return nil to leave the entity unchanged
+				...
+				return e, nil
+			},
+		})
+	}
+
+Up runs the pending migrations in order. The changed entities are saved in
+batches with PutMulti, and each migration is recorded in the bookkeeping kind,
+with a checkpoint cursor saved after each batch. If a migration fails or is
+interrupted, the next call to Up resumes it from the last checkpoint.
+Migrations can also be tested with Options.DryRun, that runs the functions
+without saving anything.
+
+All datastore operations use the aetools.Store from the context, so
+migrations can run in App Engine, through the Remote API, or against
+an aetools.MemoryStore in tests.
+
+The Main function implements a small command line interface, with the
+"up" and "status" subcommands, used by "aeremote migrate". Since the
+migrations are Go code, they must be registered in the binary that
+calls Main:
+
+	func main() {
+		c := ... // Remote API context
+		if err := migrate.Main(c, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+The package example is a complete program to copy, and the aeremote command
+can also be built with migrations, by adding a file that registers them to
+a copy of its source.
+*/
+package migrate // import "github.com/ronoaldo/aetools/migrate"
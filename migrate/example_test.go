@@ -0,0 +1,57 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package migrate_test
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine/remote_api"
+
+	"github.com/ronoaldo/aetools"
+	"github.com/ronoaldo/aetools/migrate"
+)
+
+// This example is a complete program to run data migrations through the
+// Remote API, like "aeremote migrate" with your own migrations. Copy it to
+// the main package of a command, register the migrations of your app, and
+// run it with the "up" or "status" arguments.
+func Example() {
+	migrate.Register(&migrate.Migration{
+		ID:   1,
+		Name: "Lowercase user emails",
+		Kind: "User",
+		Func: func(c context.Context, e *aetools.Entity) (*aetools.Entity, error) {
+			changed := false
+			for i, p := range e.Properties {
+				if s, ok := p.Value.(string); ok && p.Name == "email" && s != strings.ToLower(s) {
+					e.Properties[i].Value = strings.ToLower(s)
+					changed = true
+				}
+			}
+			if !changed {
+				return nil, nil
+			}
+			return e, nil
+		},
+	})
+
+	hc, err := google.DefaultClient(context.Background(),
+		"https://www.googleapis.com/auth/appengine.apis",
+		"https://www.googleapis.com/auth/userinfo.email",
+		"https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := remote_api.NewRemoteContext("my-app.appspot.com", hc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := migrate.Main(c, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
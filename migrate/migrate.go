@@ -0,0 +1,376 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package migrate
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// BookkeepingKind is the kind used to record the applied migrations.
+// Each migration is saved with its ID as the entity key ID.
+const BookkeepingKind = "_AetoolsMigration"
+
+// ErrNoMigrations is returned by Main when no migrations are registered,
+// as nothing can be checked or applied.
+var ErrNoMigrations = errors.New("migrate: no migrations registered in this binary; see migrate.Main")
+
+// Func transforms an entity during a migration. It returns the entity to
+// save, that may have a different key, or nil to keep the entity unchanged.
+type Func func(c context.Context, e *aetools.Entity) (*aetools.Entity, error)
+
+// Migration is a registered data migration.
+type Migration struct {
+	// ID is the migration number. Migrations run in ascending ID order.
+	ID int64
+	// Name is a short description of the migration.
+	Name string
+	// Kind is the kind of the entities to migrate.
+	Kind string
+	// Query selects the entities to migrate, instead of Kind.
+	// Limit and Start are ignored.
+	Query *aetools.Query
+	// Func is applied to each entity.
+	Func Func
+}
+
+// query returns a copy of the query used to select the entities.
+func (m *Migration) query() *aetools.Query {
+	if m.Query == nil {
+		return &aetools.Query{Kind: m.Kind, Orders: []string{"__key__"}}
+	}
+	q := *m.Query
+	return &q
+}
+
+// Record is the state of a migration, saved in the bookkeeping kind.
+type Record struct {
+	ID   int64
+	Name string
+	// Applied indicates that the migration finished successfully.
+	Applied bool
+	// Started and Finished are the start and end times of the migration.
+	Started, Finished time.Time
+	// Cursor is the checkpoint of an unfinished migration.
+	Cursor string
+	// Processed and Changed are the number of entities read and saved.
+	Processed, Changed int64
+}
+
+// Options configures how the migrations run.
+type Options struct {
+	// BatchSize is the number of entities read and saved at once.
+	BatchSize int
+	// DryRun runs the migration functions without saving the changes,
+	// nor recording the migration.
+	DryRun bool
+	// To is the last migration to run. Zero means all migrations.
+	To int64
+}
+
+var (
+	mu         sync.Mutex
+	migrations = make(map[int64]*Migration)
+)
+
+// Register adds m to the list of migrations. It panics if a migration with
+// the same ID was already registered, or if m is missing required fields.
+func Register(m *Migration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if m.ID <= 0 {
+		panic(fmt.Sprintf("migrate: invalid migration ID %d", m.ID))
+	}
+	if m.Func == nil || (m.Kind == "" && m.Query == nil) {
+		panic(fmt.Sprintf("migrate: migration %d requires Func and Kind or Query", m.ID))
+	}
+	if _, dup := migrations[m.ID]; dup {
+		panic(fmt.Sprintf("migrate: duplicated migration ID %d", m.ID))
+	}
+	migrations[m.ID] = m
+}
+
+// Migrations returns the registered migrations, sorted by ID.
+func Migrations() []*Migration {
+	mu.Lock()
+	defer mu.Unlock()
+	r := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		r = append(r, m)
+	}
+	sort.Sort(byID(r))
+	return r
+}
+
+// Status returns the records of all migrations found in the bookkeeping kind,
+// sorted by ID.
+func Status(c context.Context) ([]*Record, error) {
+	var r []*Record
+	q := &aetools.Query{Kind: BookkeepingKind, Orders: []string{"__key__"}}
+	for it := aetools.StoreFromContext(c).Run(c, q); ; {
+		var e aetools.Entity
+		_, err := it.Next(&e)
+		if err == datastore.Done {
+			return r, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, loadRecord(&e))
+	}
+}
+
+// Up runs the registered migrations not yet applied, in order, returning the
+// records of the migrations that were run. Unfinished migrations are resumed
+// from the last checkpoint. Processing stops at the first failure.
+func Up(c context.Context, o *Options) ([]*Record, error) {
+	var r []*Record
+	for _, m := range Migrations() {
+		if o.To > 0 && m.ID > o.To {
+			break
+		}
+		rec, err := getRecord(c, m)
+		if err != nil {
+			return r, err
+		}
+		if rec.Applied {
+			continue
+		}
+		r = append(r, rec)
+		if err := run(c, m, rec, o); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// run applies m to all selected entities, updating rec.
+func run(c context.Context, m *Migration, rec *Record, o *Options) error {
+	size := o.BatchSize
+	if size <= 0 {
+		size = 50
+	}
+	q := m.query()
+	q.Limit = size
+	q.Start = ""
+	if !o.DryRun {
+		q.Start = rec.Cursor
+	} else {
+		rec.Processed, rec.Changed = 0, 0
+	}
+	if rec.Started.IsZero() || o.DryRun {
+		rec.Started = time.Now()
+	}
+	log.Infof(c, "migrate: running migration %d %q (dry-run: %v)", m.ID, m.Name, o.DryRun)
+	s := aetools.StoreFromContext(c)
+	for {
+		var (
+			it      = s.Run(c, q)
+			keys    []*datastore.Key
+			changed []aetools.Entity
+			n       = 0
+		)
+		for {
+			var e aetools.Entity
+			_, err := it.Next(&e)
+			if err == datastore.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			n++
+			ne, err := m.Func(c, &e)
+			if err != nil {
+				return fmt.Errorf("migrate: migration %d failed at %v: %v", m.ID, e.Key, err)
+			}
+			if ne != nil {
+				keys = append(keys, ne.Key)
+				changed = append(changed, *ne)
+			}
+		}
+		if len(changed) > 0 && !o.DryRun {
+			if _, err := s.PutMulti(c, keys, changed); err != nil {
+				return err
+			}
+		}
+		rec.Processed += int64(n)
+		rec.Changed += int64(len(changed))
+		if n < size {
+			break
+		}
+		cur, err := it.Cursor()
+		if err != nil {
+			return err
+		}
+		q.Start = cur
+		if !o.DryRun {
+			rec.Cursor = cur
+			if err := putRecord(c, rec); err != nil {
+				return err
+			}
+		}
+		log.Infof(c, "migrate: migration %d processed %d entities, %d changed", m.ID, rec.Processed, rec.Changed)
+	}
+	if o.DryRun {
+		return nil
+	}
+	rec.Applied = true
+	rec.Finished = time.Now()
+	rec.Cursor = ""
+	return putRecord(c, rec)
+}
+
+// recordKey returns the bookkeeping key of the migration with the given ID.
+func recordKey(c context.Context, id int64) *datastore.Key {
+	return datastore.NewKey(c, BookkeepingKind, "", id, nil)
+}
+
+// getRecord loads the record of m, or returns a new one if
+// the migration was never run.
+func getRecord(c context.Context, m *Migration) (*Record, error) {
+	e, err := aetools.Get(c, recordKey(c, m.ID))
+	if err == datastore.ErrNoSuchEntity {
+		return &Record{ID: m.ID, Name: m.Name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadRecord(e), nil
+}
+
+// putRecord saves rec in the bookkeeping kind.
+func putRecord(c context.Context, rec *Record) error {
+	e := &aetools.Entity{Key: recordKey(c, rec.ID)}
+	e.Add(datastore.Property{Name: "name", Value: rec.Name, NoIndex: true})
+	e.Add(datastore.Property{Name: "applied", Value: rec.Applied})
+	e.Add(datastore.Property{Name: "started", Value: rec.Started})
+	e.Add(datastore.Property{Name: "finished", Value: rec.Finished})
+	e.Add(datastore.Property{Name: "cursor", Value: rec.Cursor, NoIndex: true})
+	e.Add(datastore.Property{Name: "processed", Value: rec.Processed, NoIndex: true})
+	e.Add(datastore.Property{Name: "changed", Value: rec.Changed, NoIndex: true})
+	_, err := aetools.StoreFromContext(c).PutMulti(c, []*datastore.Key{e.Key}, []aetools.Entity{*e})
+	return err
+}
+
+// loadRecord converts a bookkeeping entity to a Record.
+func loadRecord(e *aetools.Entity) *Record {
+	rec := &Record{
+		ID:        e.Key.IntID(),
+		Name:      e.GetString("name"),
+		Applied:   e.GetBool("applied"),
+		Cursor:    e.GetString("cursor"),
+		Processed: e.GetInt("processed"),
+		Changed:   e.GetInt("changed"),
+	}
+	rec.Started, _ = e.Get("started").(time.Time)
+	rec.Finished, _ = e.Get("finished").(time.Time)
+	return rec
+}
+
+// byID sorts migrations by ID.
+type byID []*Migration
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+
+// Main runs the migrate command line interface with the given arguments:
+//
+//	up [--dry-run] [--to ID] [--batch-size N]
+//	status
+//
+// The results are written to the standard output. Since migrations are
+// Go code, they must be registered in the same binary that calls Main;
+// up fails with ErrNoMigrations if none are, and status reports only the
+// recorded migrations before returning it.
+func Main(c context.Context, args []string) error {
+	return runMain(c, os.Stdout, args)
+}
+
+// runMain implements Main, writing the results to w.
+func runMain(c context.Context, w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate: missing subcommand, use up or status")
+	}
+	switch args[0] {
+	case "up":
+		o := &Options{}
+		fs := flag.NewFlagSet("up", flag.ContinueOnError)
+		fs.BoolVar(&o.DryRun, "dry-run", false, "Run the migrations without saving the changes")
+		fs.Int64Var(&o.To, "to", 0, "Last migration ID to run")
+		fs.IntVar(&o.BatchSize, "batch-size", 50, "Size for batch operations")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if len(Migrations()) == 0 {
+			return ErrNoMigrations
+		}
+		records, err := Up(c, o)
+		for _, rec := range records {
+			status := "applied"
+			if o.DryRun {
+				status = "dry-run"
+			} else if !rec.Applied {
+				status = "failed"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s: %d entities processed, %d changed\n",
+				rec.ID, rec.Name, status, rec.Processed, rec.Changed)
+		}
+		if len(records) == 0 && err == nil {
+			fmt.Fprintln(w, "No pending migrations")
+		}
+		return err
+	case "status":
+		records, err := Status(c)
+		if err != nil {
+			return err
+		}
+		byID := make(map[int64]*Record)
+		for _, rec := range records {
+			byID[rec.ID] = rec
+		}
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID\tNAME\tSTATUS\tPROCESSED\tCHANGED\n")
+		for _, m := range Migrations() {
+			rec, ok := byID[m.ID]
+			switch {
+			case !ok:
+				fmt.Fprintf(tw, "%d\t%s\tpending\t\t\n", m.ID, m.Name)
+			case rec.Applied:
+				fmt.Fprintf(tw, "%d\t%s\tapplied at %s\t%d\t%d\n", m.ID, m.Name,
+					rec.Finished.Format(time.RFC3339), rec.Processed, rec.Changed)
+			default:
+				fmt.Fprintf(tw, "%d\t%s\tunfinished\t%d\t%d\n", m.ID, m.Name, rec.Processed, rec.Changed)
+			}
+			delete(byID, m.ID)
+		}
+		for _, rec := range records {
+			if _, unknown := byID[rec.ID]; unknown {
+				fmt.Fprintf(tw, "%d\t%s\tnot registered\t%d\t%d\n", rec.ID, rec.Name, rec.Processed, rec.Changed)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if len(Migrations()) == 0 {
+			return ErrNoMigrations
+		}
+		return nil
+	}
+	return fmt.Errorf("migrate: unknown subcommand %s, use up or status", args[0])
+}
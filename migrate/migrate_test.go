@@ -0,0 +1,176 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+// setup returns a context with 25 User entities and resets the registry.
+func setup(t *testing.T) context.Context {
+	mu.Lock()
+	migrations = make(map[int64]*Migration)
+	mu.Unlock()
+	c := aetools.NewMemoryContext(context.Background())
+	var b bytes.Buffer
+	fmt.Fprint(&b, "[")
+	for i := 1; i <= 25; i++ {
+		if i > 1 {
+			fmt.Fprint(&b, ",")
+		}
+		fmt.Fprintf(&b, `{"__key__": ["User", %d], "name": "User %d"}`, i, i)
+	}
+	fmt.Fprint(&b, "]")
+	if _, err := aetools.Load(c, &b, aetools.LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// upperName is a migration that changes the name to upper case,
+// failing when the entity ID is in fail.
+func upperName(fail map[int64]bool) Func {
+	return func(c context.Context, e *aetools.Entity) (*aetools.Entity, error) {
+		if fail[e.Key.IntID()] {
+			return nil, errors.New("failure")
+		}
+		name := e.GetString("name")
+		if name == strings.ToUpper(name) {
+			return nil, nil
+		}
+		ne := &aetools.Entity{Key: e.Key}
+		ne.Add(datastore.Property{Name: "name", Value: strings.ToUpper(name)})
+		return ne, nil
+	}
+}
+
+// countUpper counts the users with upper case names.
+func countUpper(t *testing.T, c context.Context) int {
+	count := 0
+	it := aetools.StoreFromContext(c).Run(c, &aetools.Query{Kind: "User"})
+	for {
+		var e aetools.Entity
+		_, err := it.Next(&e)
+		if err == datastore.Done {
+			return count
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(e.GetString("name"), "USER") {
+			count++
+		}
+	}
+}
+
+func TestUp(t *testing.T) {
+	c := setup(t)
+	fail := map[int64]bool{17: true}
+	Register(&Migration{ID: 2, Name: "upper", Kind: "User", Func: upperName(fail)})
+	Register(&Migration{ID: 1, Name: "noop", Kind: "User", Func: func(c context.Context, e *aetools.Entity) (*aetools.Entity, error) {
+		return nil, nil
+	}})
+
+	// Dry-run changes nothing
+	records, err := Up(c, &Options{BatchSize: 10, DryRun: true, To: 2})
+	if err != nil && !strings.Contains(err.Error(), "failure") {
+		t.Fatal(err)
+	}
+	if n := countUpper(t, c); n != 0 {
+		t.Errorf("Dry-run changed %d entities", n)
+	}
+	if s, _ := Status(c); len(s) != 0 {
+		t.Errorf("Dry-run saved %d records", len(s))
+	}
+
+	// First run fails at entity 17, after saving the first batch
+	records, err = Up(c, &Options{BatchSize: 10})
+	if err == nil {
+		t.Fatal("Expected migration failure")
+	}
+	if len(records) != 2 || !records[0].Applied || records[1].Applied {
+		t.Fatalf("Unexpected records after failure: %#v", records)
+	}
+	if n := countUpper(t, c); n != 10 {
+		t.Errorf("Unexpected changed entities after failure: %d, expected 10", n)
+	}
+	if records[1].Cursor == "" {
+		t.Errorf("Missing checkpoint cursor after failure")
+	}
+
+	// Resume from the checkpoint
+	delete(fail, 17)
+	records, err = Up(c, &Options{BatchSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != 2 || !records[0].Applied {
+		t.Fatalf("Unexpected records after resume: %#v", records)
+	}
+	if records[0].Processed != 25 || records[0].Changed != 25 {
+		t.Errorf("Unexpected counts: %d processed, %d changed", records[0].Processed, records[0].Changed)
+	}
+	if n := countUpper(t, c); n != 25 {
+		t.Errorf("Unexpected changed entities after resume: %d, expected 25", n)
+	}
+
+	var w bytes.Buffer
+	if err := runMain(c, &w, []string{"status"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(w.String(), "applied at"); n != 2 {
+		t.Errorf("Unexpected status output:\n%s", w.String())
+	}
+	w.Reset()
+	if err := runMain(c, &w, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(w.String(), "No pending migrations") {
+		t.Errorf("Unexpected up output:\n%s", w.String())
+	}
+}
+
+func TestMainWithoutMigrations(t *testing.T) {
+	c := setup(t)
+	var w bytes.Buffer
+	for _, cmd := range []string{"up", "status"} {
+		if err := runMain(c, &w, []string{cmd}); err != ErrNoMigrations {
+			t.Errorf("Unexpected error from %s without migrations: %v, expected %v", cmd, err, ErrNoMigrations)
+		}
+	}
+	if strings.Contains(w.String(), "No pending migrations") {
+		t.Errorf("Unexpected output without migrations:\n%s", w.String())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	setup(t)
+	f := func(c context.Context, e *aetools.Entity) (*aetools.Entity, error) { return nil, nil }
+	Register(&Migration{ID: 1, Kind: "User", Func: f})
+	invalid := []*Migration{
+		{ID: 1, Kind: "User", Func: f},
+		{ID: 0, Kind: "User", Func: f},
+		{ID: 2, Func: f},
+		{ID: 3, Kind: "User"},
+	}
+	for _, m := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic registering %#v", m)
+				}
+			}()
+			Register(m)
+		}()
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// Stats is the progress of a Dump or Load call, reported to
+// Options.Progress after each batch.
+type Stats struct {
+	// Entities is the number of entities dumped or saved so far.
+	Entities int64
+
+	// Bytes is the size of the JSON written by Dump or DumpDir, or the
+	// EntitySize of the entities saved by Load.
+	Bytes int64
+
+	// Batches is the number of batches finished.
+	Batches int
+
+	// Elapsed is the time since the call started.
+	Elapsed time.Duration
+
+	// Total is the number of entities to load, or the number of entities
+	// of the kind to dump, from the __Stat_Kind__ statistics. It is zero
+	// when not known.
+	Total int64
+
+	// ETA is the estimated time to finish, zero when Total is not known.
+	ETA time.Duration
+}
+
+// progress tracks the Stats of a Dump or Load call.
+// A nil progress reports nothing.
+type progress struct {
+	f     func(Stats)
+	start time.Time
+	stats Stats
+}
+
+// newProgress returns the progress for o, or nil if o.Progress is not set.
+func newProgress(o *Options, total int64) *progress {
+	if o.Progress == nil {
+		return nil
+	}
+	return &progress{f: o.Progress, start: time.Now(), stats: Stats{Total: total}}
+}
+
+// addBytes adds n bytes to the stats.
+func (p *progress) addBytes(n int64) {
+	if p != nil {
+		p.stats.Bytes += n
+	}
+}
+
+// batch reports a finished batch with n entities.
+func (p *progress) batch(n int) {
+	if p == nil {
+		return
+	}
+	s := &p.stats
+	s.Entities += int64(n)
+	s.Batches++
+	s.Elapsed = time.Since(p.start)
+	s.ETA = 0
+	if s.Entities > 0 && s.Total > s.Entities {
+		s.ETA = time.Duration(float64(s.Elapsed) * float64(s.Total-s.Entities) / float64(s.Entities))
+	}
+	p.f(*s)
+}
+
+// kindCount returns the number of entities of kind from the
+// __Stat_Kind__ statistics, or zero if there are none.
+func kindCount(c context.Context, kind string) int64 {
+	q := &Query{
+		Kind:    statKindKind,
+		Filters: []Filter{{Property: "kind_name", Operator: "=", Value: kind}},
+		Limit:   1,
+	}
+	var e Entity
+	if _, err := StoreFromContext(c).Run(c, q).Next(&e); err != nil {
+		if err != datastore.Done {
+			log.Warningf(c, "Unable to read the statistics of %s: %v", kind, err)
+		}
+		return 0
+	}
+	return e.GetInt("count")
+}
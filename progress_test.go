@@ -0,0 +1,101 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestProgress(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 1; i <= 10; i++ {
+		if i > 1 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"__key__": ["User", %d], "name": "User %d"}`, i, i)
+	}
+	b.WriteString("]")
+
+	var stats []Stats
+	o := &Options{BatchSize: 3, Workers: 2, Progress: func(s Stats) { stats = append(stats, s) }}
+	c := NewMemoryContext(context.Background())
+	if _, err := Load(c, &b, o); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 4 {
+		t.Fatalf("Unexpected progress calls for Load: %d, expected 4", len(stats))
+	}
+	for i, s := range stats {
+		if s.Batches != i+1 || s.Total != 10 {
+			t.Errorf("Unexpected stats #%d for Load: %+v", i, s)
+		}
+		if i > 0 && (s.Entities <= stats[i-1].Entities || s.Bytes <= stats[i-1].Bytes) {
+			t.Errorf("Stats #%d for Load did not increase: %+v", i, s)
+		}
+	}
+	if last := stats[3]; last.Entities != 10 || last.ETA != 0 {
+		t.Errorf("Unexpected final stats for Load: %+v", last)
+	}
+
+	stats = nil
+	b.Reset()
+	o = &Options{Kind: "User", BatchSize: 4, Progress: func(s Stats) { stats = append(stats, s) }}
+	if err := Dump(c, &b, o); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("Unexpected progress calls for Dump: %d, expected 3", len(stats))
+	}
+	for i, s := range stats {
+		if s.Batches != i+1 || s.Total != 10 {
+			t.Errorf("Unexpected stats #%d for Dump: %+v", i, s)
+		}
+	}
+	last := stats[2]
+	if last.Entities != 10 || last.ETA != 0 || last.Bytes != int64(b.Len()-2) {
+		t.Errorf("Unexpected final stats for Dump: %+v, output size %d", last, b.Len())
+	}
+
+	stats = nil
+	dir, err := ioutil.TempDir("", "aetools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	o = &Options{Kind: "User", BatchSize: 4, Progress: func(s Stats) { stats = append(stats, s) }}
+	if err := DumpDir(c, dir, o); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("Unexpected progress calls for DumpDir: %d, expected 3", len(stats))
+	}
+	for i, s := range stats {
+		if s.Batches != i+1 || s.Total != 10 {
+			t.Errorf("Unexpected stats #%d for DumpDir: %+v", i, s)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "User", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	if last := stats[2]; last.Entities != 10 || last.ETA != 0 || last.Bytes != size {
+		t.Errorf("Unexpected final stats for DumpDir: %+v, output size %d", last, size)
+	}
+}
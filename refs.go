@@ -0,0 +1,165 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Reference is a key property value, pointing from an entity to another.
+type Reference struct {
+	// From is the key of the entity with the key property.
+	From *datastore.Key
+	// To is the key property value.
+	To *datastore.Key
+}
+
+// ReferenceReport summarizes the key values found in a property.
+type ReferenceReport struct {
+	// Property is the property name, prefixed by the kind, as Kind.property.
+	Property string
+	// Count is the number of key values checked.
+	Count int
+	// Dangling is the number of key values pointing to missing entities.
+	Dangling int
+	// Samples are some of the dangling references.
+	Samples []Reference
+}
+
+// CheckReferences scans the entities of the given kinds, looking for
+// *datastore.Key property values that point to entities that don't exist.
+// The existence of each referenced entity is checked with lookups by key,
+// in batches of o.BatchSize keys. One report is returned for each property
+// with key values, sorted by property name, with up to samples dangling
+// references.
+//
+// To check fixture files, load them first in a MemoryStore, so references
+// are checked against the fixture entities only.
+func CheckReferences(c context.Context, kinds []string, samples int, o *Options) ([]*ReferenceReport, error) {
+	chk := &refChecker{
+		c:       c,
+		s:       StoreFromContext(c),
+		exists:  make(map[string]bool),
+		reports: make(map[string]*ReferenceReport),
+		size:    o.BatchSize,
+		samples: samples,
+	}
+	if chk.size <= 0 {
+		chk.size = 50
+	}
+	for _, kind := range kinds {
+		ko := *o
+		ko.Kind = kind
+		err := dumpEntities(c, &ko, func(e *Entity) error {
+			for _, p := range e.Properties {
+				if k, ok := p.Value.(*datastore.Key); ok {
+					if err := chk.add(kind+"."+p.Name, Reference{From: e.Key, To: k}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := chk.flush(); err != nil {
+		return nil, err
+	}
+	r := make([]*ReferenceReport, 0, len(chk.reports))
+	for _, report := range chk.reports {
+		r = append(r, report)
+	}
+	sort.Sort(byProperty(r))
+	return r, nil
+}
+
+// refChecker checks references in batches, caching the lookup results.
+type refChecker struct {
+	c       context.Context
+	s       Store
+	exists  map[string]bool
+	reports map[string]*ReferenceReport
+	size    int
+	samples int
+
+	pending []pendingReference
+	lookup  []*datastore.Key
+}
+
+// pendingReference is a reference waiting for the lookup of its target.
+type pendingReference struct {
+	property string
+	ref      Reference
+}
+
+// add queues ref for checking, running the lookups when
+// there are enough keys to fill a batch.
+func (chk *refChecker) add(property string, ref Reference) error {
+	if _, ok := chk.reports[property]; !ok {
+		chk.reports[property] = &ReferenceReport{Property: property}
+	}
+	if ref.To.Incomplete() {
+		chk.report(property, ref, false)
+		return nil
+	}
+	chk.pending = append(chk.pending, pendingReference{property, ref})
+	id := ref.To.Encode()
+	if _, ok := chk.exists[id]; !ok {
+		// Mark as known, so the key is looked up only once
+		chk.exists[id] = false
+		chk.lookup = append(chk.lookup, ref.To)
+	}
+	if len(chk.lookup) >= chk.size {
+		return chk.flush()
+	}
+	return nil
+}
+
+// flush looks up the queued keys and reports the pending references.
+func (chk *refChecker) flush() error {
+	if len(chk.lookup) > 0 {
+		err := chk.s.GetMulti(chk.c, chk.lookup, make([]Entity, len(chk.lookup)))
+		me, ok := err.(appengine.MultiError)
+		if err != nil && !ok {
+			return err
+		}
+		for i, k := range chk.lookup {
+			if me != nil && me[i] != nil && me[i] != datastore.ErrNoSuchEntity {
+				return me[i]
+			}
+			chk.exists[k.Encode()] = me == nil || me[i] == nil
+		}
+	}
+	for _, p := range chk.pending {
+		chk.report(p.property, p.ref, chk.exists[p.ref.To.Encode()])
+	}
+	chk.pending, chk.lookup = chk.pending[:0], chk.lookup[:0]
+	return nil
+}
+
+// report accounts ref in the property report.
+func (chk *refChecker) report(property string, ref Reference, exists bool) {
+	r := chk.reports[property]
+	r.Count++
+	if exists {
+		return
+	}
+	r.Dangling++
+	if len(r.Samples) < chk.samples {
+		r.Samples = append(r.Samples, ref)
+	}
+}
+
+// byProperty sorts reports by property name.
+type byProperty []*ReferenceReport
+
+func (b byProperty) Len() int           { return len(b) }
+func (b byProperty) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byProperty) Less(i, j int) bool { return b[i].Property < b[j].Property }
@@ -0,0 +1,70 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+var refsFixture = `[
+	{"__key__": ["Customer", "ana"], "name": "Ana"},
+	{"__key__": ["Order", 1], "customer": {"type": "key", "value": ["Customer", "ana"]}},
+	{"__key__": ["Order", 2], "customer": {"type": "key", "value": ["Customer", "bob"]}},
+	{"__key__": ["Order", 3], "customer": {"type": "key", "value": ["Customer", "bob"]},
+		"items": [
+			{"type": "key", "value": ["Product", 1]},
+			{"type": "key", "value": ["Product", 2]}
+		]},
+	{"__key__": ["Product", 1], "name": "Product #1"}
+]`
+
+func TestCheckReferences(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	if err := LoadJSON(c, refsFixture, LoadSync); err != nil {
+		t.Fatal(err)
+	}
+	reports, err := CheckReferences(c, []string{"Customer", "Order", "Product"}, 10, &Options{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		Property string
+		Count    int
+		Dangling int
+		Samples  []string
+	}{
+		{"Order.customer", 3, 2, []string{"Order,2 Customer,bob", "Order,3 Customer,bob"}},
+		{"Order.items", 2, 1, []string{"Order,3 Product,2"}},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("Unexpected reports: %d, expected %d", len(reports), len(expected))
+	}
+	for i, e := range expected {
+		r := reports[i]
+		if r.Property != e.Property || r.Count != e.Count || r.Dangling != e.Dangling {
+			t.Errorf("Unexpected report %d: %s %d/%d, expected %s %d/%d",
+				i, r.Property, r.Dangling, r.Count, e.Property, e.Dangling, e.Count)
+			continue
+		}
+		if len(r.Samples) != len(e.Samples) {
+			t.Errorf("Unexpected samples for %s: %v, expected %v", r.Property, r.Samples, e.Samples)
+			continue
+		}
+		for j, s := range r.Samples {
+			if got := KeyPath(s.From) + " " + KeyPath(s.To); got != e.Samples[j] {
+				t.Errorf("Unexpected sample %d for %s: %s, expected %s", j, r.Property, got, e.Samples[j])
+			}
+		}
+	}
+
+	reports, err = CheckReferences(c, []string{"Order"}, 1, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Dangling != 2 || len(reports[0].Samples) != 1 {
+		t.Errorf("Unexpected reports with one sample: %v", reports)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// randomEntity is an Entity with random properties, implementing
+// quick.Generator to be used in round-trip tests.
+type randomEntity struct {
+	Entity
+}
+
+// offline is the context used to build keys in round-trip tests.
+var offline = OfflineContext(context.Background())
+
+func (randomEntity) Generate(r *rand.Rand, size int) reflect.Value {
+	e := randomEntity{}
+	e.Key = randomKey(r)
+	for i := 0; i < r.Intn(size+1); i++ {
+		name := fmt.Sprintf("p%d", i)
+		if r.Intn(4) == 0 {
+			// Multiple values, with one or more values
+			for j := 0; j <= r.Intn(4); j++ {
+				e.Add(datastore.Property{
+					Name:     name,
+					Value:    randomValue(r),
+					NoIndex:  r.Intn(2) == 0,
+					Multiple: true,
+				})
+			}
+			continue
+		}
+		e.Add(datastore.Property{
+			Name:    name,
+			Value:   randomValue(r),
+			NoIndex: r.Intn(2) == 0,
+		})
+	}
+	return reflect.ValueOf(e)
+}
+
+// randomKey generates a complete key with up to 3 ancestors.
+func randomKey(r *rand.Rand) *datastore.Key {
+	var k *datastore.Key
+	for i := 0; i <= r.Intn(3); i++ {
+		kind := fmt.Sprintf("Kind%d", r.Intn(10))
+		if r.Intn(2) == 0 {
+			k = datastore.NewKey(offline, kind, randomString(r), 0, k)
+		} else {
+			k = datastore.NewKey(offline, kind, "", r.Int63()+1, k)
+		}
+	}
+	return k
+}
+
+// randomString generates a non empty, valid UTF-8 string.
+func randomString(r *rand.Rand) string {
+	var b bytes.Buffer
+	for i := 0; i <= r.Intn(20); i++ {
+		switch r.Intn(3) {
+		case 0:
+			b.WriteRune(rune('a' + r.Intn(26)))
+		case 1:
+			b.WriteRune(rune(r.Intn(0x80)))
+		default:
+			b.WriteRune(rune(0xA0 + r.Intn(0xD000)))
+		}
+	}
+	return b.String()
+}
+
+// randomValue generates a random value of any supported property type.
+func randomValue(r *rand.Rand) interface{} {
+	switch r.Intn(10) {
+	case 0:
+		return r.Int63() - r.Int63()
+	case 1:
+		return []int64{0, 1, -1, math.MaxInt64, math.MinInt64}[r.Intn(5)]
+	case 2:
+		return (r.Float64() - 0.5) * math.Pow(10, float64(r.Intn(600)-300))
+	case 3:
+		return randomString(r)
+	case 4:
+		return r.Intn(2) == 0
+	case 5:
+		return randomKey(r)
+	case 6:
+		return appengine.BlobKey(randomString(r))
+	case 7:
+		sec := r.Int63n(253402300799) - 62135596800
+		return time.Unix(sec, r.Int63n(1e9)).UTC()
+	case 8:
+		b := make([]byte, r.Intn(64))
+		r.Read(b)
+		return b
+	default:
+		return nil
+	}
+}
+
+// roundTrip encodes e with Map and JSON, and then decodes it back.
+func roundTrip(e *Entity) (*Entity, error) {
+	m, err := e.Map()
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	a, err := parseJSONArray(strings.NewReader("[" + string(b) + "]"))
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntity(offline, a[0].(map[string]interface{}))
+}
+
+// byName sorts properties by name, keeping the order of multiple values.
+type byName datastore.PropertyList
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+// sameValue compares property values, handling the types that can't
+// be compared with reflect.DeepEqual.
+func sameValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *datastore.Key:
+		k, ok := b.(*datastore.Key)
+		return ok && a.Equal(k)
+	case time.Time:
+		t, ok := b.(time.Time)
+		return ok && a.Equal(t)
+	case []byte:
+		v, ok := b.([]byte)
+		return ok && bytes.Equal(a, v)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sameEntity compares the key and properties of a and b.
+func sameEntity(a, b *Entity) error {
+	if !a.Key.Equal(b.Key) {
+		return fmt.Errorf("key mismatch: %v != %v", a.Key, b.Key)
+	}
+	pa := append(datastore.PropertyList{}, a.Properties...)
+	pb := append(datastore.PropertyList{}, b.Properties...)
+	sort.Stable(byName(pa))
+	sort.Stable(byName(pb))
+	if len(pa) != len(pb) {
+		return fmt.Errorf("property count mismatch: %d != %d", len(pa), len(pb))
+	}
+	for i := range pa {
+		x, y := pa[i], pb[i]
+		if x.Name != y.Name || x.NoIndex != y.NoIndex || x.Multiple != y.Multiple {
+			return fmt.Errorf("property %d mismatch: %#v != %#v", i, x, y)
+		}
+		if !sameValue(x.Value, y.Value) {
+			return fmt.Errorf("property %s value mismatch: %#v (%T) != %#v (%T)",
+				x.Name, x.Value, x.Value, y.Value, y.Value)
+		}
+	}
+	return nil
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	f := func(e randomEntity) bool {
+		decoded, err := roundTrip(&e.Entity)
+		if err != nil {
+			t.Logf("Round-trip error: %v", err)
+			return false
+		}
+		if err := sameEntity(&e.Entity, decoded); err != nil {
+			t.Logf("Round-trip mismatch: %v", err)
+			return false
+		}
+		return true
+	}
+	cfg := &quick.Config{MaxCount: 1000, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(f, cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeNumbers(t *testing.T) {
+	cases := []struct {
+		JSON     string
+		Expected interface{}
+	}{
+		{`1`, int64(1)},
+		{`-9223372036854775808`, int64(math.MinInt64)},
+		{`9223372036854775807`, int64(math.MaxInt64)},
+		{`1.0`, float64(1)},
+		{`1e5`, float64(1e5)},
+		{`1E-5`, float64(1e-5)},
+		{`{"type": "float", "value": 2}`, float64(2)},
+		{`{"type": "int", "value": 2, "indexed": false}`, int64(2)},
+	}
+	for _, c := range cases {
+		e, err := DecodeEntities(offline, strings.NewReader(`[{"__key__": ["Test", 1], "v": `+c.JSON+`}]`))
+		if err != nil {
+			t.Errorf("Unexpected error decoding %s: %v", c.JSON, err)
+			continue
+		}
+		if v := e[0].Get("v"); !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("Unexpected value decoding %s: %#v (%T), expected %#v", c.JSON, v, v, c.Expected)
+		}
+	}
+
+	invalid := []string{
+		`9223372036854775808`,
+		`-9223372036854775809`,
+		`1e400`,
+		`{"type": "int", "value": 1.5}`,
+		`{"type": "int", "value": "1"}`,
+	}
+	for _, s := range invalid {
+		_, err := DecodeEntities(offline, strings.NewReader(`[{"__key__": ["Test", 1], "v": `+s+`}]`))
+		if err == nil {
+			t.Errorf("Expected error decoding %s", s)
+		}
+	}
+}
+
+func TestDateTimePrecision(t *testing.T) {
+	d := time.Date(2014, 1, 1, 10, 0, 0, 123456789, time.UTC)
+	e := &Entity{Key: datastore.NewKey(offline, "Test", "", 1, nil)}
+	e.Add(datastore.Property{Name: "d", Value: d})
+	decoded, err := roundTrip(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := decoded.Get("d").(time.Time); !v.Equal(d) {
+		t.Errorf("Unexpected time after round-trip: %v, expected %v", v, d)
+	}
+}
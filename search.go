@@ -0,0 +1,405 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/search"
+)
+
+// maxIndexBatchSize is the maximum number of documents saved with
+// a single call to the Search API.
+const maxIndexBatchSize = 200
+
+// Document is a Search API document, with its ID, fields and metadata.
+// It implements search.FieldLoadSaver, and is encoded as JSON like an
+// entity: the "__id__" attribute has the document ID, "__rank__" has
+// the document rank, and each field is an attribute. Repeated fields are
+// JSON arrays. Text fields are JSON strings and number fields are JSON
+// numbers. Other fields use an object with the "type" and "value"
+// attributes, where type is one of "atom", "html", "date" (a string in the
+// RFC3339 format) or "geopoint" (an object with "lat" and "lng"). Text and
+// HTML fields with a language other than English also have the "language"
+// attribute. Facets are saved in the "__facets__" object, with atom facets
+// as JSON strings and number facets as JSON numbers.
+type Document struct {
+	ID     string
+	Fields []search.Field
+	Meta   search.DocumentMetadata
+}
+
+// Load implements search.FieldLoadSaver.
+func (d *Document) Load(fields []search.Field, meta *search.DocumentMetadata) error {
+	d.Fields = fields
+	if meta != nil {
+		d.Meta = *meta
+	}
+	return nil
+}
+
+// Save implements search.FieldLoadSaver.
+func (d *Document) Save() ([]search.Field, *search.DocumentMetadata, error) {
+	return d.Fields, &d.Meta, nil
+}
+
+// MarshalJSON encodes the document as JSON.
+func (d *Document) MarshalJSON() ([]byte, error) {
+	m, err := d.Map()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// Map converts the document into a JSON compatible map.
+func (d *Document) Map() (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	m["__id__"] = d.ID
+	if d.Meta.Rank != 0 {
+		m["__rank__"] = d.Meta.Rank
+	}
+	for _, f := range d.Fields {
+		if f.Derived {
+			continue
+		}
+		v, err := encodeField(f)
+		if err != nil {
+			return nil, err
+		}
+		addValue(m, f.Name, v)
+	}
+	if len(d.Meta.Facets) > 0 {
+		facets := make(map[string]interface{})
+		for _, f := range d.Meta.Facets {
+			switch v := f.Value.(type) {
+			case search.Atom:
+				addValue(facets, f.Name, string(v))
+			case float64:
+				addValue(facets, f.Name, float(v))
+			default:
+				return nil, fmt.Errorf("aetools: invalid facet %s with type %T", f.Name, f.Value)
+			}
+		}
+		m["__facets__"] = facets
+	}
+	return m, nil
+}
+
+// addValue adds v to m[name], turning it into an array if the name
+// is repeated.
+func addValue(m map[string]interface{}, name string, v interface{}) {
+	old, ok := m[name]
+	if !ok {
+		m[name] = v
+		return
+	}
+	if a, ok := old.([]interface{}); ok {
+		m[name] = append(a, v)
+		return
+	}
+	m[name] = []interface{}{old, v}
+}
+
+// encodeField returns the JSON compatible value of f.
+func encodeField(f search.Field) (interface{}, error) {
+	var t string
+	var v interface{}
+	switch value := f.Value.(type) {
+	case string:
+		if f.Language == "" || f.Language == "en" {
+			return value, nil
+		}
+		t, v = "text", value
+	case search.Atom:
+		t, v = "atom", string(value)
+	case search.HTML:
+		t, v = "html", string(value)
+	case float64:
+		return float(value), nil
+	case time.Time:
+		t, v = "date", value.UTC().Format(time.RFC3339Nano)
+	case appengine.GeoPoint:
+		t, v = "geopoint", map[string]interface{}{"lat": float(value.Lat), "lng": float(value.Lng)}
+	default:
+		return nil, fmt.Errorf("aetools: invalid field %s with type %T", f.Name, f.Value)
+	}
+	m := map[string]interface{}{"type": t, "value": v}
+	if f.Language != "" && f.Language != "en" {
+		m["language"] = f.Language
+	}
+	return m, nil
+}
+
+// decodeDocument decodes a document from its JSON object.
+func decodeDocument(m map[string]interface{}) (*Document, error) {
+	d := new(Document)
+	id, ok := m["__id__"].(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("aetools: document with missing or invalid __id__: %v", m["__id__"])
+	}
+	d.ID = id
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		v := m[n]
+		switch n {
+		case "__id__":
+		case "__rank__":
+			rank, err := decodeInt(numberOf(v))
+			if err != nil {
+				return nil, fmt.Errorf("aetools: document %s has invalid __rank__: %v", id, v)
+			}
+			d.Meta.Rank = int(rank)
+		case "__facets__":
+			facets, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("aetools: document %s has invalid __facets__: %v", id, v)
+			}
+			if err := d.decodeFacets(facets); err != nil {
+				return nil, err
+			}
+		default:
+			values, ok := v.([]interface{})
+			if !ok {
+				values = []interface{}{v}
+			}
+			for _, v := range values {
+				f, err := decodeField(n, v)
+				if err != nil {
+					return nil, fmt.Errorf("aetools: document %s: %v", id, err)
+				}
+				d.Fields = append(d.Fields, f)
+			}
+		}
+	}
+	return d, nil
+}
+
+// decodeFacets adds the facets in m to the document.
+func (d *Document) decodeFacets(m map[string]interface{}) error {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		values, ok := m[n].([]interface{})
+		if !ok {
+			values = []interface{}{m[n]}
+		}
+		for _, v := range values {
+			f := search.Facet{Name: n}
+			switch v := v.(type) {
+			case string:
+				f.Value = search.Atom(v)
+			case json.Number:
+				n, err := v.Float64()
+				if err != nil {
+					return err
+				}
+				f.Value = n
+			default:
+				return fmt.Errorf("aetools: document %s has invalid facet %s: %v", d.ID, n, v)
+			}
+			d.Meta.Facets = append(d.Meta.Facets, f)
+		}
+	}
+	return nil
+}
+
+// decodeField decodes the field name with the JSON value v.
+func decodeField(name string, v interface{}) (search.Field, error) {
+	f := search.Field{Name: name}
+	switch value := v.(type) {
+	case string:
+		f.Value = value
+		return f, nil
+	case json.Number:
+		n, err := value.Float64()
+		if err != nil {
+			return f, fmt.Errorf("invalid number field %s: %v", name, v)
+		}
+		f.Value = n
+		return f, nil
+	case map[string]interface{}:
+		f.Language, _ = value["language"].(string)
+		t, _ := value["type"].(string)
+		switch t {
+		case "number":
+			n, err := numberOf(value["value"]).Float64()
+			if err != nil {
+				return f, fmt.Errorf("invalid number field %s: %v", name, value["value"])
+			}
+			f.Value = n
+			return f, nil
+		case "geopoint":
+			g, _, err := decodeCustom(context.Background(), name, "geopoint", value["value"])
+			if err != nil {
+				return f, err
+			}
+			f.Value = g
+			return f, nil
+		}
+		s, ok := value["value"].(string)
+		if !ok {
+			return f, fmt.Errorf("invalid %s field %s: %v", t, name, value["value"])
+		}
+		switch t {
+		case "text":
+			f.Value = s
+		case "atom":
+			f.Value = search.Atom(s)
+		case "html":
+			f.Value = search.HTML(s)
+		case "date":
+			d, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return f, fmt.Errorf("invalid date field %s: %v", name, s)
+			}
+			f.Value = d
+		default:
+			return f, fmt.Errorf("invalid type of field %s: %v", name, value["type"])
+		}
+		return f, nil
+	}
+	return f, fmt.Errorf("invalid field %s: %v", name, v)
+}
+
+// numberOf returns v as a json.Number, or an invalid number
+// if v is not a number.
+func numberOf(v interface{}) json.Number {
+	n, _ := v.(json.Number)
+	return n
+}
+
+// DumpIndex exports all documents of the Search API index name, writing
+// them to w as a JSON array. Documents are listed in ID order, in batches
+// of o.BatchSize. The o.PrettyPrint option is honored.
+func DumpIndex(c context.Context, w io.Writer, name string, o *Options) error {
+	index, err := search.Open(name)
+	if err != nil {
+		return err
+	}
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	var b bytes.Buffer
+	b.WriteString("[")
+	count := 0
+	last := ""
+	for {
+		// The start ID is inclusive, so the last document is read again
+		opts := &search.ListOptions{StartID: last, Limit: batchSize}
+		if last != "" {
+			opts.Limit++
+		}
+		read := 0
+		for it := index.List(c, opts); ; {
+			var d Document
+			id, err := it.Next(&d)
+			if err == search.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if id == last {
+				continue
+			}
+			d.ID, last = id, id
+			read++
+			m, err := d.Map()
+			if err != nil {
+				return err
+			}
+			var j []byte
+			if o.PrettyPrint {
+				j, err = json.MarshalIndent(m, "", "  ")
+			} else {
+				j, err = json.Marshal(m)
+			}
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				b.WriteString(",\n")
+			}
+			b.Write(j)
+			count++
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return err
+		}
+		b.Reset()
+		if read < batchSize {
+			break
+		}
+	}
+	log.Infof(c, "Exported %d documents from index %s", count, name)
+	_, err = w.Write([]byte("]\n"))
+	return err
+}
+
+// LoadIndex reads the documents in the JSON array from r, in the format
+// written by DumpIndex, and saves them in the Search API index name, in
+// batches of o.BatchSize up to 200 documents. It returns the number of
+// documents saved. If any document is invalid, nothing is saved.
+func LoadIndex(c context.Context, r io.Reader, name string, o *Options) (int, error) {
+	index, err := search.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	a, err := parseJSONArray(r)
+	if err != nil {
+		return 0, err
+	}
+	docs := make([]*Document, 0, len(a))
+	for _, v := range a {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return 0, ErrInvalidElementType
+		}
+		d, err := decodeDocument(m)
+		if err != nil {
+			return 0, err
+		}
+		docs = append(docs, d)
+	}
+	batchSize := o.BatchSize
+	if batchSize <= 0 || batchSize > maxIndexBatchSize {
+		batchSize = maxIndexBatchSize
+	}
+	saved := 0
+	for start := 0; start < len(docs); start += batchSize {
+		end := start + batchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		ids := make([]string, 0, end-start)
+		srcs := make([]interface{}, 0, end-start)
+		for _, d := range docs[start:end] {
+			ids = append(ids, d.ID)
+			srcs = append(srcs, d)
+		}
+		if _, err := index.PutMulti(c, ids, srcs); err != nil {
+			return saved, err
+		}
+		saved += len(ids)
+		log.Infof(c, "Saved %d documents in index %s ...", saved, name)
+	}
+	return saved, nil
+}
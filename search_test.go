@@ -0,0 +1,83 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/search"
+)
+
+func TestDocumentRoundTrip(t *testing.T) {
+	d := &Document{
+		ID: "doc-1",
+		Fields: []search.Field{
+			{Name: "Body", Value: search.HTML("<p>Olá</p>"), Language: "pt"},
+			{Name: "Created", Value: time.Date(2016, 3, 1, 10, 30, 0, 0, time.UTC)},
+			{Name: "Location", Value: appengine.GeoPoint{Lat: -23.5, Lng: -46.6}},
+			{Name: "Price", Value: 12.5},
+			{Name: "Tag", Value: search.Atom("red")},
+			{Name: "Tag", Value: search.Atom("blue")},
+			{Name: "Title", Value: "A title"},
+		},
+		Meta: search.DocumentMetadata{
+			Rank:   42,
+			Facets: []search.Facet{{Name: "Color", Value: search.Atom("red")}, {Name: "Size", Value: 10.0}},
+		},
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"__id__":"doc-1"`,
+		`"__rank__":42`,
+		`"Tag":[{"type":"atom","value":"red"},{"type":"atom","value":"blue"}]`,
+		`"Title":"A title"`,
+		`"Price":12.5`,
+		`"language":"pt"`,
+		`"__facets__":{"Color":"red","Size":10.0}`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("Missing %s in %s", s, b)
+		}
+	}
+
+	a, err := parseJSONArray(strings.NewReader("[" + string(b) + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeDocument(a[0].(map[string]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("Unexpected document after round trip:\n%#v\nexpected\n%#v", got, d)
+	}
+}
+
+func TestDecodeDocumentErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"Title": "no id"}`,
+		`{"__id__": "1", "__rank__": "high"}`,
+		`{"__id__": "1", "Tag": {"type": "atom", "value": 1}}`,
+		`{"__id__": "1", "When": {"type": "date", "value": "yesterday"}}`,
+		`{"__id__": "1", "Where": {"type": "geopoint", "value": {"lat": 100, "lng": 0}}}`,
+		`{"__id__": "1", "Flag": true}`,
+		`{"__id__": "1", "__facets__": {"Color": true}}`,
+	} {
+		a, err := parseJSONArray(strings.NewReader("[" + s + "]"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decodeDocument(a[0].(map[string]interface{})); err == nil {
+			t.Errorf("Expected error decoding %s", s)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// MaxEntityBytes is the maximum size of an entity in the datastore.
+	MaxEntityBytes = 1048572
+
+	// MaxIndexedBytes is the maximum size of an indexed string value.
+	MaxIndexedBytes = 1500
+
+	// nearLimitBytes is the size of indexed values reported as
+	// close to MaxIndexedBytes.
+	nearLimitBytes = MaxIndexedBytes * 8 / 10
+
+	// noIndexAverageBytes is the average value size of indexed properties
+	// reported as candidates to be unindexed.
+	noIndexAverageBytes = 100
+)
+
+// PropertySize returns an estimate of the size of p when saved
+// in the datastore, including the property name.
+func PropertySize(p datastore.Property) int64 {
+	return int64(len(p.Name)) + valueSize(p.Value)
+}
+
+// EntitySize returns an estimate of the size of e when saved
+// in the datastore, including the key and all properties.
+func EntitySize(e *Entity) int64 {
+	var size int64
+	if e.Key != nil {
+		size += int64(len(e.Key.String()))
+	}
+	for _, p := range e.Properties {
+		size += PropertySize(p)
+	}
+	return size
+}
+
+// valueSize returns an estimate of the size of v when saved in the datastore.
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil, bool:
+		return 1
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case datastore.ByteString:
+		return int64(len(v))
+	case appengine.BlobKey:
+		return int64(len(v))
+	case *datastore.Key:
+		return int64(len(v.String()))
+	case appengine.GeoPoint:
+		return 16
+	case *datastore.Entity:
+		size := int64(0)
+		for _, p := range v.Properties {
+			size += PropertySize(p)
+		}
+		return size
+	}
+	return 8
+}
+
+// SizeReport is the result of AnalyzeSize.
+type SizeReport struct {
+	// Kind is the analyzed kind.
+	Kind string
+	// Count is the number of entities analyzed.
+	Count int
+	// Bytes is the estimated size of all entities.
+	Bytes int64
+	// Largest are the largest entities, in descending size order.
+	Largest []*EntitySizeReport
+	// Properties are the size statistics of all properties, in
+	// descending size order.
+	Properties []*PropertySizeReport
+	// NearLimit are the indexed values close to MaxIndexedBytes, in
+	// descending size order.
+	NearLimit []*ValueSizeReport
+	// NoIndexCandidates are the indexed properties with large values,
+	// that are likely not used in queries and could be unindexed, in
+	// descending size order of the index entries.
+	NoIndexCandidates []*PropertySizeReport
+}
+
+// EntitySizeReport is the size of an entity and its properties.
+type EntitySizeReport struct {
+	Key   *datastore.Key
+	Bytes int64
+	// Properties are the property sizes, in descending order.
+	Properties []*PropertySizeReport
+}
+
+// PropertySizeReport is the size statistics of a property.
+type PropertySizeReport struct {
+	Name string
+	// Count is the number of values, and Indexed the number of indexed values.
+	Count, Indexed int
+	// Bytes is the size of all values, and MaxBytes the size of the largest one.
+	Bytes, MaxBytes int64
+	// IndexBytes is an estimate of the size of the built-in index entries.
+	IndexBytes int64
+}
+
+// ValueSizeReport is the size of a property value.
+type ValueSizeReport struct {
+	Key      *datastore.Key
+	Property string
+	Bytes    int64
+}
+
+// AnalyzeSize scans the entities of the given kind, computing the estimated
+// size of each entity and property. Up to top entities and values are kept
+// in the Largest and NearLimit fields of the report; none are kept if top
+// is zero or negative.
+func AnalyzeSize(c context.Context, kind string, top int, o *Options) (*SizeReport, error) {
+	r := &SizeReport{Kind: kind}
+	props := make(map[string]*PropertySizeReport)
+	ko := *o
+	ko.Kind = kind
+	err := dumpEntities(c, &ko, func(e *Entity) error {
+		keySize := int64(len(e.Key.String()))
+		er := &EntitySizeReport{Key: e.Key, Bytes: EntitySize(e)}
+		byName := make(map[string]*PropertySizeReport)
+		for _, p := range e.Properties {
+			size := valueSize(p.Value)
+			for _, m := range []map[string]*PropertySizeReport{props, byName} {
+				ps := m[p.Name]
+				if ps == nil {
+					ps = &PropertySizeReport{Name: p.Name}
+					m[p.Name] = ps
+				}
+				ps.Count++
+				ps.Bytes += size
+				if size > ps.MaxBytes {
+					ps.MaxBytes = size
+				}
+				if !p.NoIndex {
+					ps.Indexed++
+					// One entry for ascending and one for descending order
+					ps.IndexBytes += 2 * (int64(len(kind)+len(p.Name)) + size + keySize)
+				}
+			}
+			if top > 0 && !p.NoIndex && size >= nearLimitBytes {
+				r.NearLimit = append(r.NearLimit, &ValueSizeReport{Key: e.Key, Property: p.Name, Bytes: size})
+				sort.Sort(byValueSize(r.NearLimit))
+				if len(r.NearLimit) > top {
+					r.NearLimit = r.NearLimit[:top]
+				}
+			}
+		}
+		r.Count++
+		r.Bytes += er.Bytes
+		if top <= 0 {
+			return nil
+		}
+		if len(r.Largest) < top || er.Bytes > r.Largest[len(r.Largest)-1].Bytes {
+			er.Properties = sortedPropertySizes(byName)
+			r.Largest = append(r.Largest, er)
+			sort.Sort(byEntitySize(r.Largest))
+			if len(r.Largest) > top {
+				r.Largest = r.Largest[:top]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Properties = sortedPropertySizes(props)
+	for _, ps := range r.Properties {
+		if ps.Indexed > 0 && ps.Bytes/int64(ps.Count) >= noIndexAverageBytes {
+			r.NoIndexCandidates = append(r.NoIndexCandidates, ps)
+		}
+	}
+	sort.Sort(byIndexSize(r.NoIndexCandidates))
+	return r, nil
+}
+
+// sortedPropertySizes returns the reports in m sorted by size.
+func sortedPropertySizes(m map[string]*PropertySizeReport) []*PropertySizeReport {
+	r := make([]*PropertySizeReport, 0, len(m))
+	for _, ps := range m {
+		r = append(r, ps)
+	}
+	sort.Sort(byPropertySize(r))
+	return r
+}
+
+// byEntitySize sorts entities by size in descending order.
+type byEntitySize []*EntitySizeReport
+
+func (b byEntitySize) Len() int      { return len(b) }
+func (b byEntitySize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byEntitySize) Less(i, j int) bool {
+	if b[i].Bytes != b[j].Bytes {
+		return b[i].Bytes > b[j].Bytes
+	}
+	return CompareKeys(b[i].Key, b[j].Key) < 0
+}
+
+// byPropertySize sorts properties by size in descending order.
+type byPropertySize []*PropertySizeReport
+
+func (b byPropertySize) Len() int      { return len(b) }
+func (b byPropertySize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPropertySize) Less(i, j int) bool {
+	if b[i].Bytes != b[j].Bytes {
+		return b[i].Bytes > b[j].Bytes
+	}
+	return b[i].Name < b[j].Name
+}
+
+// byIndexSize sorts properties by index size in descending order.
+type byIndexSize []*PropertySizeReport
+
+func (b byIndexSize) Len() int      { return len(b) }
+func (b byIndexSize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byIndexSize) Less(i, j int) bool {
+	if b[i].IndexBytes != b[j].IndexBytes {
+		return b[i].IndexBytes > b[j].IndexBytes
+	}
+	return b[i].Name < b[j].Name
+}
+
+// byValueSize sorts values by size in descending order.
+type byValueSize []*ValueSizeReport
+
+func (b byValueSize) Len() int      { return len(b) }
+func (b byValueSize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byValueSize) Less(i, j int) bool {
+	if b[i].Bytes != b[j].Bytes {
+		return b[i].Bytes > b[j].Bytes
+	}
+	return CompareKeys(b[i].Key, b[j].Key) < 0
+}
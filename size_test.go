@@ -0,0 +1,76 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestAnalyzeSize(t *testing.T) {
+	c := NewMemoryContext(context.Background())
+	s := StoreFromContext(c)
+	var (
+		keys     []*datastore.Key
+		entities []Entity
+	)
+	for i := 1; i <= 20; i++ {
+		e := Entity{Key: datastore.NewKey(c, "Page", "", int64(i), nil)}
+		e.Add(datastore.Property{Name: "title", Value: "Page"})
+		e.Add(datastore.Property{Name: "summary", Value: strings.Repeat("s", 100+i)})
+		e.Add(datastore.Property{Name: "body", Value: strings.Repeat("b", 1000*i), NoIndex: true})
+		if i%5 == 0 {
+			e.Add(datastore.Property{Name: "slug", Value: strings.Repeat("x", 1400+i)})
+		}
+		keys = append(keys, e.Key)
+		entities = append(entities, e)
+	}
+	if _, err := s.PutMulti(c, keys, entities); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := AnalyzeSize(c, "Page", 3, &Options{BatchSize: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 20 {
+		t.Errorf("Unexpected count: %d, expected 20", r.Count)
+	}
+	if len(r.Largest) != 3 {
+		t.Fatalf("Unexpected largest entities: %d, expected 3", len(r.Largest))
+	}
+	for i, id := range []int64{20, 19, 18} {
+		if r.Largest[i].Key.IntID() != id {
+			t.Errorf("Unexpected largest entity at %d: %v, expected id %d", i, r.Largest[i].Key, id)
+		}
+	}
+	if p := r.Largest[0].Properties[0]; p.Name != "body" || p.Bytes != 20000 {
+		t.Errorf("Unexpected largest property: %s %d, expected body 20000", p.Name, p.Bytes)
+	}
+	if r.Properties[0].Name != "body" {
+		t.Errorf("Unexpected largest property: %s, expected body", r.Properties[0].Name)
+	}
+	if len(r.NearLimit) != 3 || r.NearLimit[0].Property != "slug" || r.NearLimit[0].Bytes != 1420 {
+		t.Errorf("Unexpected values near limit: %#v", r.NearLimit)
+	}
+	var candidates []string
+	for _, p := range r.NoIndexCandidates {
+		candidates = append(candidates, p.Name)
+	}
+	if s := strings.Join(candidates, ","); s != "slug,summary" {
+		t.Errorf("Unexpected noindex candidates: %s, expected slug,summary", s)
+	}
+
+	// No largest entities or values are kept with top=0
+	r, err = AnalyzeSize(c, "Page", 0, &Options{BatchSize: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 20 || len(r.Largest) != 0 || len(r.NearLimit) != 0 {
+		t.Errorf("Unexpected report with top=0: count %d, %d largest, %d near limit", r.Count, len(r.Largest), len(r.NearLimit))
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Store is a datastore backend used by the aetools functions.
+// The default Store uses the google.golang.org/appengine/datastore API,
+// and a different implementation can be set in the context using
+// WithStore, allowing the same functions to run outside App Engine.
+//
+// All implementations use *datastore.Key to represent entity keys,
+// and return datastore.Done when a query has no more results,
+// datastore.ErrNoSuchEntity for missing entities, and
+// appengine.MultiError for errors in multiple entity operations.
+type Store interface {
+	// GetMulti loads the entities for the given keys into dst,
+	// which must have the same length as keys.
+	GetMulti(c context.Context, keys []*datastore.Key, dst []Entity) error
+
+	// PutMulti saves src with the given keys, returning the
+	// complete keys of the saved entities.
+	PutMulti(c context.Context, keys []*datastore.Key, src []Entity) ([]*datastore.Key, error)
+
+	// DeleteMulti removes the entities with the given keys.
+	DeleteMulti(c context.Context, keys []*datastore.Key) error
+
+	// Run executes the query q, returning an iterator for its results.
+	Run(c context.Context, q *Query) Iterator
+
+	// AllocateIDs reserves n integer IDs for the given kind and parent,
+	// returning complete keys using them.
+	AllocateIDs(c context.Context, kind string, parent *datastore.Key, n int) ([]*datastore.Key, error)
+}
+
+// Iterator is the result of running a query in a Store.
+type Iterator interface {
+	// Next returns the key of the next result. If e is not nil, the result
+	// is loaded into it, including the key. When there are no more results,
+	// datastore.Done is returned as the error.
+	Next(e *Entity) (*datastore.Key, error)
+
+	// Cursor returns an encoded cursor for the current iterator position,
+	// that can be used as Query.Start to resume the query.
+	Cursor() (string, error)
+}
+
+// Query is a backend independent datastore query, executed by Store.Run.
+type Query struct {
+	// Kind is the entity kind to query. Required.
+	Kind string
+	// Ancestor restricts the results to the descendants of this key.
+	Ancestor *datastore.Key
+	// Filters are the property filters, combined with a logical AND.
+	Filters []Filter
+	// Orders are the property names used for sorting, prefixed with "-"
+	// for descending order. Use "__key__" to sort by key.
+	Orders []string
+	// Limit is the maximum number of results. Zero means no limit.
+	Limit int
+	// Offset is the number of results to skip.
+	Offset int
+	// KeysOnly indicates that only keys are returned.
+	KeysOnly bool
+	// Start and End are encoded cursors where the query starts and ends.
+	Start string
+	End   string
+}
+
+// Filter is a query filter, comparing the named property with Value.
+// Operator is one of "=", "<", "<=", ">" or ">=". Use "__key__" as
+// Property and a *datastore.Key as Value to filter by key.
+type Filter struct {
+	Property string
+	Operator string
+	Value    interface{}
+}
+
+// storeKey is the context key that holds the Store to use.
+var storeKey = "holds an aetools.Store"
+
+// WithStore returns a copy of parent that makes the aetools functions,
+// like Dump and Load, use s as the datastore backend.
+func WithStore(parent context.Context, s Store) context.Context {
+	return context.WithValue(parent, &storeKey, s)
+}
+
+// StoreFromContext returns the Store set with WithStore in c, or
+// the App Engine datastore Store if none was set.
+func StoreFromContext(c context.Context) Store {
+	if s, ok := c.Value(&storeKey).(Store); ok {
+		return s
+	}
+	return appEngineStore{}
+}
+
+// Get loads the entity with key k using the Store from c.
+func Get(c context.Context, k *datastore.Key) (*Entity, error) {
+	l := make([]Entity, 1)
+	err := StoreFromContext(c).GetMulti(c, []*datastore.Key{k}, l)
+	if me, ok := err.(appengine.MultiError); ok {
+		err = me[0]
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l[0], nil
+}
+
+// NewAppEngineStore returns a Store that uses the
+// google.golang.org/appengine/datastore API.
+func NewAppEngineStore() Store {
+	return appEngineStore{}
+}
+
+// appEngineStore implements the Store using the App Engine APIs.
+type appEngineStore struct{}
+
+func (appEngineStore) GetMulti(c context.Context, keys []*datastore.Key, dst []Entity) error {
+	err := datastore.GetMulti(c, keys, dst)
+	for i := range dst {
+		dst[i].Key = keys[i]
+	}
+	return err
+}
+
+func (appEngineStore) PutMulti(c context.Context, keys []*datastore.Key, src []Entity) ([]*datastore.Key, error) {
+	return datastore.PutMulti(c, keys, src)
+}
+
+func (appEngineStore) DeleteMulti(c context.Context, keys []*datastore.Key) error {
+	return datastore.DeleteMulti(c, keys)
+}
+
+func (appEngineStore) AllocateIDs(c context.Context, kind string, parent *datastore.Key, n int) ([]*datastore.Key, error) {
+	low, _, err := datastore.AllocateIDs(c, kind, parent, n)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = datastore.NewKey(c, kind, "", low+int64(i), parent)
+	}
+	return keys, nil
+}
+
+func (appEngineStore) Run(c context.Context, q *Query) Iterator {
+	dq := datastore.NewQuery(q.Kind)
+	if q.Ancestor != nil {
+		dq = dq.Ancestor(q.Ancestor)
+	}
+	for _, f := range q.Filters {
+		dq = dq.Filter(f.Property+" "+f.Operator, f.Value)
+	}
+	for _, o := range q.Orders {
+		dq = dq.Order(o)
+	}
+	if q.Limit > 0 {
+		dq = dq.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		dq = dq.Offset(q.Offset)
+	}
+	if q.KeysOnly {
+		dq = dq.KeysOnly()
+	}
+	if q.Start != "" {
+		cur, err := datastore.DecodeCursor(q.Start)
+		if err != nil {
+			return &errIterator{err}
+		}
+		dq = dq.Start(cur)
+	}
+	if q.End != "" {
+		cur, err := datastore.DecodeCursor(q.End)
+		if err != nil {
+			return &errIterator{err}
+		}
+		dq = dq.End(cur)
+	}
+	return &appEngineIterator{it: dq.Run(c), keysOnly: q.KeysOnly}
+}
+
+// appEngineIterator wraps a datastore.Iterator as an Iterator.
+type appEngineIterator struct {
+	it       *datastore.Iterator
+	keysOnly bool
+}
+
+func (i *appEngineIterator) Next(e *Entity) (*datastore.Key, error) {
+	var dst interface{}
+	if e != nil && !i.keysOnly {
+		dst = e
+	}
+	k, err := i.it.Next(dst)
+	if e != nil {
+		e.Key = k
+	}
+	return k, err
+}
+
+func (i *appEngineIterator) Cursor() (string, error) {
+	cur, err := i.it.Cursor()
+	if err != nil {
+		return "", err
+	}
+	return cur.String(), nil
+}
+
+// errIterator is an Iterator that aways fails with err.
+type errIterator struct {
+	err error
+}
+
+func (i *errIterator) Next(e *Entity) (*datastore.Key, error) { return nil, i.err }
+func (i *errIterator) Cursor() (string, error)                 { return "", i.err }
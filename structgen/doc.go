@@ -0,0 +1,32 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package structgen generates Go struct definitions from existing Datastore
+data.
+
+The struct fields can be inferred from the datastore statistics, using the
+__Stat_PropertyType_PropertyName_Kind__ entities, or from a set of entities
+previously exported with aetools.Dump, such as a fixture file.
+Each property is mapped to a field with the proper datastore tag, including
+the "noindex" option for unindexed properties, and repeated properties are
+mapped to slices:
+
+	s, err := structgen.FromStats(c, "Account")
+	if err != nil {
+		// Handle error
+	}
+	err = structgen.Write(os.Stdout, "models", s)
+
+This is useful to write Go code that handles entities created by other
+runtimes, like Java or Python, based on the real data.
+*/
+package structgen // import "github.com/ronoaldo/aetools/structgen"
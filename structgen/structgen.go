@@ -0,0 +1,388 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package structgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+	"github.com/ronoaldo/aetools/bigquerysync"
+)
+
+// Field describes a struct field that maps to an entity property.
+type Field struct {
+	// Name is the Go field name.
+	Name string
+	// Property is the datastore property name, used in the field tag.
+	Property string
+	// Type is the Go type of each property value.
+	Type string
+	// Repeated is true when the property has multiple values,
+	// generating a slice field.
+	Repeated bool
+	// NoIndex is true if no indexed values were found for the property.
+	NoIndex bool
+	// Others holds any other Go types found for the same property,
+	// since the datastore allows different types across entities.
+	Others []string
+}
+
+// Struct describes a Go struct that maps to an entity kind.
+type Struct struct {
+	Name   string
+	Kind   string
+	Fields []*Field
+}
+
+// statTypes maps the property types used in the datastore statistics
+// to their Go types.
+var statTypes = map[string]string{
+	"String":        "string",
+	"Text":          "string",
+	"Category":      "string",
+	"Email":         "string",
+	"IM":            "string",
+	"Link":          "string",
+	"PhoneNumber":   "string",
+	"PostalAddress": "string",
+	"Integer":       "int64",
+	"Rating":        "int64",
+	"Float":         "float64",
+	"Boolean":       "bool",
+	"Date/Time":     "time.Time",
+	"Key":           "*datastore.Key",
+	"BlobKey":       "appengine.BlobKey",
+	"Blob":          "[]byte",
+	"ShortBlob":     "[]byte",
+	"GeoPt":         "appengine.GeoPoint",
+}
+
+// imports maps the Go types to the package they require.
+var imports = map[string]string{
+	"time.Time":          "time",
+	"*datastore.Key":     "google.golang.org/appengine/datastore",
+	"appengine.BlobKey":  "google.golang.org/appengine",
+	"appengine.GeoPoint": "google.golang.org/appengine",
+}
+
+// FromStats builds the struct for kind using the datastore statistics.
+// Properties with more values than the kind entity count are repeated,
+// and properties without built-in index entries are noindex.
+// Property types without a Go counterpart, like NULL, are ignored.
+func FromStats(c context.Context, kind string) (*Struct, error) {
+	kindStats := new(bigquerysync.StatByKind)
+	k := datastore.NewKey(c, bigquerysync.StatByKindKind, kind, 0, nil)
+	e, err := aetools.Get(c, k)
+	if err == nil {
+		err = datastore.LoadStruct(kindStats, e.Properties)
+	}
+	if err != nil && !fieldMismatch(err) {
+		return nil, fmt.Errorf("structgen: no stats for '%s': %s", kind, err.Error())
+	}
+
+	b := newBuilder()
+	q := &aetools.Query{
+		Kind:    bigquerysync.StatByPropertyKind,
+		Filters: []aetools.Filter{{Property: "kind_name", Operator: "=", Value: kind}},
+	}
+	for it := aetools.StoreFromContext(c).Run(c, q); ; {
+		var e aetools.Entity
+		s := new(bigquerysync.StatByProperty)
+		_, err := it.Next(&e)
+		if err == datastore.Done {
+			break
+		}
+		if err == nil {
+			err = datastore.LoadStruct(s, e.Properties)
+		}
+		if err != nil && !fieldMismatch(err) {
+			return nil, fmt.Errorf("structgen: can't load property stats %s: %s", kind, err.Error())
+		}
+		t, ok := statTypes[s.Type]
+		if !ok {
+			continue
+		}
+		p := b.property(s.Name)
+		p.add(t, s.Count, s.IndexCount == 0)
+		if kindStats.Count > 0 && p.count > kindStats.Count {
+			p.repeated = true
+		}
+	}
+	return b.build(kind), nil
+}
+
+// FromEntities builds the struct for kind using the property values of
+// entities. A property is repeated if any of its values have the Multiple
+// flag set, and noindex if all of its values have NoIndex set.
+// Entities with a key of another kind are ignored.
+func FromEntities(kind string, entities []aetools.Entity) *Struct {
+	b := newBuilder()
+	for _, e := range entities {
+		if e.Key != nil && e.Key.Kind() != kind {
+			continue
+		}
+		for _, prop := range e.Properties {
+			p := b.property(prop.Name)
+			if prop.Multiple {
+				p.repeated = true
+			}
+			if t := goType(prop.Value); t != "" {
+				p.add(t, 1, prop.NoIndex)
+			}
+		}
+	}
+	return b.build(kind)
+}
+
+// Write generates the source code of a Go package named pkg, containing
+// the given structs, and writes it formatted to w.
+func Write(w io.Writer, pkg string, structs ...*Struct) error {
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "package %s\n\n", pkg)
+
+	deps := make(map[string]bool)
+	for _, s := range structs {
+		for _, f := range s.Fields {
+			if imp, ok := imports[f.Type]; ok {
+				deps[imp] = true
+			}
+		}
+	}
+	if len(deps) > 0 {
+		paths := make([]string, 0, len(deps))
+		for imp := range deps {
+			paths = append(paths, imp)
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(&src, "import (\n")
+		for _, imp := range paths {
+			fmt.Fprintf(&src, "%q\n", imp)
+		}
+		fmt.Fprintf(&src, ")\n\n")
+	}
+
+	for _, s := range structs {
+		fmt.Fprintf(&src, "// %s is the model for the datastore kind %q.\n", s.Name, s.Kind)
+		fmt.Fprintf(&src, "type %s struct {\n", s.Name)
+		for _, f := range s.Fields {
+			writeField(&src, f)
+		}
+		fmt.Fprintf(&src, "}\n\n")
+	}
+
+	b, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("structgen: unable to format source: %s", err.Error())
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// writeField writes the field declaration for f. Fields whose property
+// names can't be used in struct tags are written as comments.
+func writeField(w io.Writer, f *Field) {
+	if len(f.Others) > 0 {
+		fmt.Fprintf(w, "// %s is also stored as %s.\n", f.Name, strings.Join(f.Others, ", "))
+	}
+	prefix := ""
+	if !validPropertyName(f.Property) {
+		fmt.Fprintf(w, "// %s has a property name that can't be loaded into structs.\n", f.Name)
+		prefix = "// "
+	}
+	t := f.Type
+	if f.Repeated {
+		t = "[]" + t
+	}
+	tag := f.Property
+	if f.NoIndex {
+		tag += ",noindex"
+	}
+	fmt.Fprintf(w, "%s%s %s `datastore:%q`\n", prefix, f.Name, t, tag)
+}
+
+// property collects the types found for a single property.
+type property struct {
+	name     string
+	types    map[string]int64
+	count    int64
+	indexed  bool
+	repeated bool
+}
+
+// add records count values of type t.
+func (p *property) add(t string, count int64, noIndex bool) {
+	p.types[t] += count
+	p.count += count
+	if !noIndex {
+		p.indexed = true
+	}
+}
+
+// builder accumulates the properties of a kind.
+type builder struct {
+	props map[string]*property
+}
+
+func newBuilder() *builder {
+	return &builder{props: make(map[string]*property)}
+}
+
+// property returns the property named n, creating it if needed.
+func (b *builder) property(n string) *property {
+	p, ok := b.props[n]
+	if !ok {
+		p = &property{name: n, types: make(map[string]int64)}
+		b.props[n] = p
+	}
+	return p
+}
+
+// build generates the Struct with the fields sorted by property name.
+// The most frequent type of each property is used as the field type.
+func (b *builder) build(kind string) *Struct {
+	s := &Struct{Name: identifier(kind), Kind: kind}
+	names := make([]string, 0, len(b.props))
+	for n, p := range b.props {
+		if len(p.types) > 0 {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	used := make(map[string]int)
+	for _, n := range names {
+		p := b.props[n]
+		types := byCount{names: make([]string, 0, len(p.types)), count: p.types}
+		for t := range p.types {
+			types.names = append(types.names, t)
+		}
+		sort.Sort(types)
+
+		name := identifier(n)
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s%d", name, used[name])
+		}
+		s.Fields = append(s.Fields, &Field{
+			Name:     name,
+			Property: n,
+			Type:     types.names[0],
+			Repeated: p.repeated,
+			NoIndex:  !p.indexed,
+			Others:   types.names[1:],
+		})
+	}
+	return s
+}
+
+// byCount implements sort.Interface to sort type names by their
+// value count, in descending order, and then by name.
+type byCount struct {
+	names []string
+	count map[string]int64
+}
+
+func (b byCount) Len() int      { return len(b.names) }
+func (b byCount) Swap(i, j int) { b.names[i], b.names[j] = b.names[j], b.names[i] }
+func (b byCount) Less(i, j int) bool {
+	ci, cj := b.count[b.names[i]], b.count[b.names[j]]
+	if ci != cj {
+		return ci > cj
+	}
+	return b.names[i] < b.names[j]
+}
+
+// goType returns the Go type name of the property value v,
+// or an empty string if v is nil or of an unsupported type.
+func goType(v interface{}) string {
+	switch v.(type) {
+	case int, int32, int64:
+		return "int64"
+	case float32, float64:
+		return "float64"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case time.Time:
+		return "time.Time"
+	case *datastore.Key:
+		return "*datastore.Key"
+	case appengine.BlobKey:
+		return "appengine.BlobKey"
+	case appengine.GeoPoint:
+		return "appengine.GeoPoint"
+	case []byte:
+		return "[]byte"
+	}
+	return ""
+}
+
+// initialisms are name parts written in upper case, following
+// the Go naming conventions.
+var initialisms = map[string]bool{
+	"ID": true, "URL": true, "URI": true, "HTML": true, "JSON": true,
+	"XML": true, "HTTP": true, "API": true, "UUID": true, "IP": true,
+}
+
+// identifier converts a kind or property name into an exported Go
+// identifier, using the non alphanumeric characters as word separators.
+func identifier(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b bytes.Buffer
+	for _, p := range parts {
+		if initialisms[strings.ToUpper(p)] {
+			b.WriteString(strings.ToUpper(p))
+			continue
+		}
+		r := []rune(p)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	id := b.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "X" + id
+	}
+	return id
+}
+
+// validPropertyName reports whether name can be used in a datastore
+// struct tag.
+func validPropertyName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, s := range strings.Split(name, ".") {
+		if s == "" {
+			return false
+		}
+		for i, r := range s {
+			if r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+				continue
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// fieldMismatch checks if err is caused by a stat property without a
+// corresponding struct field, which can be safely ignored.
+func fieldMismatch(err error) bool {
+	_, ok := err.(*datastore.ErrFieldMismatch)
+	return ok
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package structgen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/ronoaldo/aetools"
+)
+
+func TestFromEntities(t *testing.T) {
+	entities := []aetools.Entity{
+		{Properties: datastore.PropertyList{
+			{Name: "name", Value: "Ronoaldo"},
+			{Name: "height", Value: int64(175)},
+			{Name: "tags", Value: "a", Multiple: true},
+			{Name: "tags", Value: "b", Multiple: true},
+			{Name: "bio", Value: "Long text", NoIndex: true},
+			{Name: "created_at", Value: time.Now()},
+		}},
+		{Properties: datastore.PropertyList{
+			{Name: "name", Value: "Other"},
+			{Name: "height", Value: "tall"},
+			{Name: "empty", Value: nil},
+		}},
+	}
+	s := FromEntities("user_profile", entities)
+	if s.Name != "UserProfile" {
+		t.Errorf("Unexpected struct name: %s, expected UserProfile", s.Name)
+	}
+	expected := []Field{
+		{Name: "Bio", Property: "bio", Type: "string", NoIndex: true},
+		{Name: "CreatedAt", Property: "created_at", Type: "time.Time"},
+		{Name: "Height", Property: "height", Type: "int64"},
+		{Name: "Name", Property: "name", Type: "string"},
+		{Name: "Tags", Property: "tags", Type: "string", Repeated: true},
+	}
+	if len(s.Fields) != len(expected) {
+		t.Fatalf("Unexpected field count: %d, expected %d: %#v", len(s.Fields), len(expected), s.Fields)
+	}
+	for i, e := range expected {
+		f := s.Fields[i]
+		if f.Name != e.Name || f.Property != e.Property || f.Type != e.Type ||
+			f.Repeated != e.Repeated || f.NoIndex != e.NoIndex {
+			t.Errorf("Unexpected field %d: %#v, expected %#v", i, f, e)
+		}
+	}
+	if h := s.Fields[2]; len(h.Others) != 1 || h.Others[0] != "string" {
+		t.Errorf("Unexpected other types for height: %v, expected [string]", h.Others)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	s := &Struct{
+		Name: "Account",
+		Kind: "Account",
+		Fields: []*Field{
+			{Name: "Emails", Property: "Emails", Type: "string", Repeated: true},
+			{Name: "Owner", Property: "owner", Type: "*datastore.Key"},
+			{Name: "Notes", Property: "notes", Type: "string", NoIndex: true},
+			{Name: "XMyProp", Property: "my prop", Type: "string"},
+		},
+	}
+	var b bytes.Buffer
+	if err := Write(&b, "models", s); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Generated source:\n%s", b.String())
+	// Ignore the gofmt alignment
+	src := strings.Join(strings.Fields(b.String()), " ")
+	for _, exp := range []string{
+		"package models",
+		`"google.golang.org/appengine/datastore"`,
+		"type Account struct",
+		"Emails []string `datastore:\"Emails\"`",
+		"Owner *datastore.Key `datastore:\"owner\"`",
+		"Notes string `datastore:\"notes,noindex\"`",
+		"// XMyProp string",
+	} {
+		if !strings.Contains(src, exp) {
+			t.Errorf("Generated source is missing %q", exp)
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Expected string
+	}{
+		{"Account", "Account"},
+		{"user_id", "UserID"},
+		{"creation-date", "CreationDate"},
+		{"address.city", "AddressCity"},
+		{"2fa", "X2fa"},
+		{"__key__", "Key"},
+	}
+	for _, c := range cases {
+		if id := identifier(c.Name); id != c.Expected {
+			t.Errorf("identifier(%q) = %q, expected %q", c.Name, id, c.Expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+var (
+	// retryDelay is the wait before the first retry of a batch.
+	retryDelay = 500 * time.Millisecond
+	// maxRetryDelay is the longest wait between two retries.
+	maxRetryDelay = 30 * time.Second
+)
+
+// IsTransientError reports if err is a datastore timeout or contention
+// error, so the operation can be retried. A MultiError is transient if all
+// of its errors are. Errors of a done context are not transient.
+func IsTransientError(err error) bool {
+	if err == nil || err == context.DeadlineExceeded || err == context.Canceled {
+		return false
+	}
+	if me, ok := err.(appengine.MultiError); ok {
+		found := false
+		for _, err := range me {
+			if err == nil {
+				continue
+			}
+			if !IsTransientError(err) {
+				return false
+			}
+			found = true
+		}
+		return found
+	}
+	if err == datastore.ErrConcurrentTransaction || appengine.IsTimeoutError(err) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "datastore operation timed out") ||
+		strings.Contains(msg, "too much contention")
+}
+
+// limiter enforces the MaxQPS and MaxEntitiesPerSecond options, shared
+// by all workers of a Dump or Load call.
+type limiter struct {
+	qps, eps float64
+
+	mu         sync.Mutex
+	nextCall   time.Time
+	nextEntity time.Time
+}
+
+// newLimiter returns the limiter for o, or nil if o has no rate limits.
+func newLimiter(o *Options) *limiter {
+	if o.MaxQPS <= 0 && o.MaxEntitiesPerSecond <= 0 {
+		return nil
+	}
+	return &limiter{qps: o.MaxQPS, eps: o.MaxEntitiesPerSecond}
+}
+
+// wait blocks until a datastore call with n entities can be made.
+// It returns early with an error if c is done.
+func (l *limiter) wait(c context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := now
+	if l.qps > 0 {
+		if l.nextCall.After(at) {
+			at = l.nextCall
+		}
+	}
+	if l.eps > 0 {
+		if l.nextEntity.After(at) {
+			at = l.nextEntity
+		}
+	}
+	if l.qps > 0 {
+		l.nextCall = at.Add(time.Duration(float64(time.Second) / l.qps))
+	}
+	if l.eps > 0 {
+		l.nextEntity = at.Add(time.Duration(float64(n) * float64(time.Second) / l.eps))
+	}
+	l.mu.Unlock()
+	return sleep(c, at.Sub(now))
+}
+
+// backoff counts the retries of a failed operation.
+type backoff struct {
+	max   int
+	count int
+	delay time.Duration
+}
+
+// wait sleeps before the next retry of an operation that failed with err.
+// It returns a non-nil error if err is not transient, there are no retries
+// left, or c is done.
+func (b *backoff) wait(c context.Context, err error) error {
+	if b.count >= b.max || !IsTransientError(err) {
+		return err
+	}
+	b.count++
+	switch {
+	case b.delay == 0:
+		b.delay = retryDelay
+	case b.delay < maxRetryDelay:
+		b.delay *= 2
+		if b.delay > maxRetryDelay {
+			b.delay = maxRetryDelay
+		}
+	}
+	log.Warningf(c, "Retrying in %v (%d of %d), after error: %v", b.delay, b.count, b.max, err)
+	return sleep(c, b.delay)
+}
+
+// sleep pauses for d, returning early with an error if c is done.
+func sleep(c context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-c.Done():
+		return c.Err()
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var errTimeout = errors.New("API error 5 (datastore_v3: TIMEOUT): The datastore operation timed out, or the data was temporarily unavailable.")
+
+// flakyStore is a MemoryStore that fails the first puts, and the
+// first query results after a few entities, with a timeout error.
+type flakyStore struct {
+	*MemoryStore
+	putErrors  int
+	nextErrors int
+	puts       int
+}
+
+func (s *flakyStore) PutMulti(c context.Context, keys []*datastore.Key, src []Entity) ([]*datastore.Key, error) {
+	s.puts++
+	if s.putErrors > 0 {
+		s.putErrors--
+		return nil, errTimeout
+	}
+	return s.MemoryStore.PutMulti(c, keys, src)
+}
+
+func (s *flakyStore) Run(c context.Context, q *Query) Iterator {
+	return &flakyIterator{Iterator: s.MemoryStore.Run(c, q), s: s}
+}
+
+type flakyIterator struct {
+	Iterator
+	s    *flakyStore
+	read int
+}
+
+func (i *flakyIterator) Next(e *Entity) (*datastore.Key, error) {
+	if i.read == 2 && i.s.nextErrors > 0 {
+		i.s.nextErrors--
+		return nil, errTimeout
+	}
+	i.read++
+	return i.Iterator.Next(e)
+}
+
+func TestIsTransientError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errTimeout, true},
+		{errors.New("API error 2 (datastore_v3: CONCURRENT_TRANSACTION): too much contention on these datastore entities. please try again."), true},
+		{datastore.ErrConcurrentTransaction, true},
+		{context.DeadlineExceeded, false},
+		{errors.New("bad entity"), false},
+		{appengine.MultiError{nil, errTimeout}, true},
+		{appengine.MultiError{errTimeout, errors.New("bad entity")}, false},
+		{appengine.MultiError{nil, nil}, false},
+	} {
+		if got := IsTransientError(tc.err); got != tc.transient {
+			t.Errorf("IsTransientError(%v) = %v, expected %v", tc.err, got, tc.transient)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	// Load retries the batch
+	s := &flakyStore{MemoryStore: NewMemoryStore(), putErrors: 2}
+	c := WithStore(OfflineContext(context.Background()), s)
+	fixture := `[{"__key__": ["User", 1]}, {"__key__": ["User", 2]}, {"__key__": ["User", 3]}]`
+	r, err := Load(c, strings.NewReader(fixture), &Options{BatchSize: 2, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Written != 3 || s.puts != 4 {
+		t.Errorf("Unexpected result %+v after %d puts", r, s.puts)
+	}
+
+	// Load gives up after o.Retries
+	s.putErrors, s.puts = 3, 0
+	if _, err := Load(c, strings.NewReader(fixture), &Options{Retries: 2}); err == nil {
+		t.Errorf("Expected error after 2 retries")
+	}
+	if s.puts != 3 {
+		t.Errorf("Unexpected puts %d, expected 3", s.puts)
+	}
+
+	// Incomplete keys are never retried
+	s.putErrors, s.puts = 1, 0
+	if _, err := Load(c, strings.NewReader(`[{"__key__": ["User", 0]}]`), &Options{Retries: 2}); err == nil {
+		t.Errorf("Expected error with incomplete key")
+	}
+	if s.puts != 1 {
+		t.Errorf("Unexpected puts %d, expected 1", s.puts)
+	}
+
+	// Dump restarts the batch, without duplicates
+	s = &flakyStore{MemoryStore: NewMemoryStore(), nextErrors: 2}
+	c = WithStore(OfflineContext(context.Background()), s)
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 1; i <= 10; i++ {
+		if i > 1 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"__key__": ["User", %d]}`, i)
+	}
+	b.WriteString("]")
+	if _, err := Load(c, &b, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	err = dumpEntities(c, &Options{Kind: "User", BatchSize: 4, Retries: 3}, func(e *Entity) error {
+		ids = append(ids, e.Key.IntID())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5 6 7 8 9 10]" {
+		t.Errorf("Unexpected entities dumped: %v", ids)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 1; i <= 10; i++ {
+		if i > 1 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"__key__": ["User", %d]}`, i)
+	}
+	b.WriteString("]")
+	fixture := b.String()
+
+	for _, o := range []*Options{
+		// 5 calls: the last one starts after 4 * 20ms
+		{BatchSize: 2, MaxQPS: 50},
+		// 10 entities: the last batch starts after 8 * 10ms
+		{BatchSize: 2, MaxEntitiesPerSecond: 100, Workers: 4},
+	} {
+		c := NewMemoryContext(context.Background())
+		start := time.Now()
+		if _, err := Load(c, strings.NewReader(fixture), o); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+			t.Errorf("Load with %+v took %v, expected at least 80ms", o, elapsed)
+		}
+	}
+}
@@ -4,9 +4,30 @@
 package aetools
 
 import (
+	"flag"
+	"testing"
+
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
 )
 
+var useSDK = flag.Bool("appengine", false, "Run the tests against the App Engine SDK instead of the in-memory store")
+
+// newTestContext returns a context for tests and a cleanup function.
+// By default, the context uses a MemoryStore; with the -appengine flag
+// the tests run against the App Engine SDK, using aetest.
+func newTestContext(t *testing.T) (context.Context, func()) {
+	if !*useSDK {
+		return NewMemoryContext(context.Background()), func() {}
+	}
+	c, clean, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, clean
+}
+
 // fixture is a sample output that describes the main expected
 // serializatoin format when testing.
 var fixture = []byte(`[
@@ -0,0 +1,403 @@
+// Copyright 2014 Ronoaldo JLP <ronoaldo@gmail.com>
+// Licensed under the Apache License, Version 2.0
+
+package aetools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The YAML support in this file covers the subset of YAML needed to
+// represent the entity format: block mappings and sequences, plain,
+// single and double-quoted scalars, JSON flow collections, and full
+// line comments. Anchors, tags, block scalars and multiple documents
+// are not supported.
+
+var (
+	// yamlPlain matches strings that can be written as plain YAML scalars.
+	yamlPlain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ./-]*$`)
+
+	// yamlNumber matches plain scalars parsed as numbers.
+	yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlReserved are the plain scalars that are not read back as strings.
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "null": true, "yes": true, "no": true,
+	"on": true, "off": true, "y": true, "n": true,
+}
+
+// writeYAML writes v, a value decoded from JSON, as a YAML block at the
+// given indentation. Mapping keys are sorted, except for "__key__" that
+// is aways the first one.
+func writeYAML(w *bytes.Buffer, v interface{}, indent int) error {
+	pad := strings.Repeat(" ", indent)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			w.WriteString(pad + "{}\n")
+			return nil
+		}
+		for _, k := range yamlKeys(v) {
+			w.WriteString(pad + yamlScalar(k) + ":")
+			if err := writeYAMLValue(w, v[k], indent); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			w.WriteString(pad + "[]\n")
+			return nil
+		}
+		for _, i := range v {
+			w.WriteString(pad + "-")
+			if m, ok := i.(map[string]interface{}); ok && len(m) > 0 {
+				// Mapping entries start in the same line as the "-"
+				var b bytes.Buffer
+				if err := writeYAML(&b, m, indent+2); err != nil {
+					return err
+				}
+				w.WriteString(" ")
+				w.Write(b.Bytes()[indent+2:])
+				continue
+			}
+			if err := writeYAMLValue(w, i, indent); err != nil {
+				return err
+			}
+		}
+	default:
+		s, err := yamlFlow(v)
+		if err != nil {
+			return err
+		}
+		w.WriteString(pad + s + "\n")
+	}
+	return nil
+}
+
+// writeYAMLValue writes v after a mapping key or sequence dash. Scalars
+// and sequences of scalars are written in the same line, and other values
+// as a nested block.
+func writeYAMLValue(w *bytes.Buffer, v interface{}, indent int) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) > 0 {
+			w.WriteString("\n")
+			return writeYAML(w, t, indent+2)
+		}
+	case []interface{}:
+		for _, i := range t {
+			switch i.(type) {
+			case map[string]interface{}, []interface{}:
+				w.WriteString("\n")
+				return writeYAML(w, t, indent+2)
+			}
+		}
+	}
+	s, err := yamlFlow(v)
+	if err != nil {
+		return err
+	}
+	w.WriteString(" " + s + "\n")
+	return nil
+}
+
+// yamlFlow returns v as a single line value.
+func yamlFlow(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return yamlScalar(s), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// yamlScalar returns s as a plain scalar if possible, or as a
+// double-quoted scalar using the JSON string escapes.
+func yamlScalar(s string) string {
+	if yamlPlain.MatchString(s) && !yamlReserved[strings.ToLower(s)] && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// yamlKeys returns the keys of m, sorted, with "__key__" first.
+func yamlKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if k != "__key__" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := m["__key__"]; ok {
+		keys = append([]string{"__key__"}, keys...)
+	}
+	return keys
+}
+
+// yamlLine is a non-empty line of a YAML document.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlParser parses a YAML document into the same values decoded
+// from JSON with json.Decoder.UseNumber.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML reads a YAML document from r.
+func parseYAML(r io.Reader) (interface{}, error) {
+	p := new(yamlParser)
+	s := bufio.NewScanner(r)
+	num := 0
+	for s.Scan() {
+		num++
+		line := strings.TrimRight(s.Text(), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || strings.HasPrefix(text, "#") || (len(p.lines) == 0 && text == "---") {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("aetools: yaml line %d: tabs are not allowed for indentation", num)
+		}
+		p.lines = append(p.lines, yamlLine{num, len(line) - len(text), text})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+// errorf returns an error at the current line.
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	l := p.lines[len(p.lines)-1]
+	if p.pos < len(p.lines) {
+		l = p.lines[p.pos]
+	}
+	return fmt.Errorf("aetools: yaml line %d: %s", l.num, fmt.Sprintf(format, args...))
+}
+
+// parseBlock parses the node starting at the current line.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	l := p.lines[p.pos]
+	if isYAMLItem(l.text) {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok := splitYAMLEntry(l.text); ok {
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	return parseYAMLScalar(l.text)
+}
+
+// parseSequence parses the sequence items at the given indentation.
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := make([]interface{}, 0)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		l := &p.lines[p.pos]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var (
+			v   interface{}
+			err error
+		)
+		if rest == "" {
+			p.pos++
+			v, err = p.parseNested(indent)
+		} else {
+			// Parse the item contents as if they started in a new line
+			l.indent += len(l.text) - len(rest)
+			l.text = rest
+			v, err = p.parseBlock(l.indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+// parseMapping parses the mapping entries at the given indentation.
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		k, rest, ok := splitYAMLEntry(p.lines[p.pos].text)
+		if !ok {
+			return nil, p.errorf("expected a mapping entry")
+		}
+		key, err := parseYAMLScalar(k)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			s = k
+		}
+		if _, dup := m[s]; dup {
+			return nil, p.errorf("duplicated key %s", s)
+		}
+		p.pos++
+		var v interface{}
+		if rest == "" {
+			// Sequences can be at the same indentation of the parent key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+				v, err = p.parseSequence(indent)
+			} else {
+				v, err = p.parseNested(indent)
+			}
+		} else {
+			v, err = parseYAMLScalar(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[s] = v
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return m, nil
+}
+
+// parseNested parses the block after an empty value, if it is more
+// indented than the parent node, or returns nil.
+func (p *yamlParser) parseNested(indent int) (interface{}, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return p.parseBlock(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+// isYAMLItem reports if text is a sequence item.
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseYAMLFlowSequence parses a flow sequence of scalars, like [User, 1],
+// that is not valid JSON.
+func parseYAMLFlowSequence(s string) (interface{}, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("aetools: invalid yaml value %s", s)
+	}
+	seq := make([]interface{}, 0)
+	body := strings.TrimSpace(s[1 : len(s)-1])
+	for start, i, quote := 0, 0, byte(0); body != "" && i <= len(body); i++ {
+		if i < len(body) {
+			switch ch := body[i]; {
+			case quote == '"' && ch == '\\':
+				i++
+				continue
+			case quote != 0 && ch == quote:
+				quote = 0
+				continue
+			case quote != 0:
+				continue
+			case ch == '"' || ch == '\'':
+				quote = ch
+				continue
+			case ch == '[' || ch == '{' || ch == ']' || ch == '}':
+				return nil, fmt.Errorf("aetools: unsupported nested yaml collection in %s", s)
+			case ch != ',':
+				continue
+			}
+		}
+		v, err := parseYAMLScalar(strings.TrimSpace(body[start:i]))
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+		start = i + 1
+	}
+	return seq, nil
+}
+
+// splitYAMLEntry splits a mapping entry in the key and value texts.
+func splitYAMLEntry(text string) (key, value string, ok bool) {
+	start := 0
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		// Skip the quoted key, so a colon inside it is not used
+		q, i := text[0], 1
+		for ; i < len(text); i++ {
+			if q == '"' && text[i] == '\\' {
+				i++
+				continue
+			}
+			if text[i] == q {
+				if q == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+					i++
+					continue
+				}
+				break
+			}
+		}
+		if i >= len(text) {
+			return "", "", false
+		}
+		start = i + 1
+	} else if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLScalar parses a single line value.
+func parseYAMLScalar(s string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, `"`), strings.HasPrefix(s, "["), strings.HasPrefix(s, "{"):
+		v, err := parseJSONValue(s)
+		if err != nil && strings.HasPrefix(s, "[") {
+			return parseYAMLFlowSequence(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("aetools: invalid yaml value %s: %v", s, err)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("aetools: invalid yaml value %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "|"), strings.HasPrefix(s, ">"),
+		strings.HasPrefix(s, "&"), strings.HasPrefix(s, "*"), strings.HasPrefix(s, "!"):
+		return nil, fmt.Errorf("aetools: unsupported yaml value %s", s)
+	case s == "~" || s == "null":
+		return nil, nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case yamlNumber.MatchString(s):
+		return json.Number(s), nil
+	}
+	return s, nil
+}